@@ -0,0 +1,105 @@
+package dc
+
+import "bufio"
+import "bytes"
+import "fmt"
+import "strconv"
+import "strings"
+
+/* When a source goes away part way through a download of a file with a TTH,
+ * everyone else who might have the file is looked for, both in the file lists
+ * we have and by searching the hub. Whoever turns up is added as a source, and
+ * if any of them are around the download carries on from one of them rather
+ * than waiting to be retried. Search results which come in later do the same
+ * for downloads which are still waiting. */
+
+/* Looks for other sources of a download whose source went away, and moves it
+ * over to one of them if there's anyone around. Returns whether it should be
+ * queued up again. The client must be locked. */
+func (c *Client) alternate(dl *download) bool {
+  if dl.tth == "" || dl.fileList() { return false }
+  c.findSources(dl)
+  c.searchTTH(dl.tth)
+  return c.reroute(dl, "")
+}
+
+/* Asks the hub who has the file with a TTH. The results are sent back through
+ * the hub, whether or not we're active. */
+func (c *Client) searchTTH(tth string) {
+  if c.Hub.write == nil { return }
+  sendf(c.Hub.write, "Search", func(w *bufio.Writer) {
+    fmt.Fprintf(w, "Hub:%s F?T?0?9?TTH:%s", c.Nick, tth)
+  })
+}
+
+/* Moves a download over to one of its other sources which is around, other
+ * than the one to skip, keeping whoever it was from as a source in case they
+ * come back. Returns whether there was anyone. The client must be locked. */
+func (c *Client) reroute(dl *download, skip string) bool {
+  for i, s := range dl.sources {
+    if s.Nick == skip || !c.reachable(s.Nick) { continue }
+    dl.sources[i] = Source{Nick: dl.nick, File: dl.file}
+    dl.nick, dl.file = s.Nick, s.File
+    return true
+  }
+  return false
+}
+
+/* Picks apart a search result for a file, which looks like
+ * "<nick> <path>\x05<size> <slots>\x05TTH:<tth> (<hub>)". Results for
+ * directories and those without a TTH are left out. */
+func parseResult(data []byte) (src Source, size int64, tth string, ok bool) {
+  parts := bytes.Split(data, []byte("\x05"))
+  if len(parts) < 3 { return }
+  idx := bytes.IndexByte(parts[0], ' ')
+  if idx <= 0 { return }
+  src.Nick = string(parts[0][:idx])
+  src.File = strings.Replace(string(parts[0][idx + 1:]), "\\", "/", -1)
+  src.File = strings.TrimPrefix(src.File, "/")
+
+  fields := strings.Fields(string(parts[1]))
+  if len(fields) == 0 { return }
+  size, err := strconv.ParseInt(fields[0], 10, 64)
+  if err != nil { return }
+
+  hub := strings.Fields(string(parts[2]))
+  if len(hub) == 0 || !strings.HasPrefix(hub[0], "TTH:") { return }
+  tth = strings.TrimPrefix(hub[0], "TTH:")
+  return src, size, tth, src.File != "" && tth != ""
+}
+
+/* Adds someone who turned up in a search as a source of everything queued
+ * with the same file. Downloads waiting to be retried are moved over to them
+ * and started again straight away. */
+func (c *Client) foundSource(src Source, size int64, tth string) {
+  c.Lock()
+  waiting := make(map[*download]bool)
+  for _, dl := range c.failed {
+    waiting[dl] = dl.retrying()
+  }
+  retry := make([]*download, 0)
+  added, segmented := false, false
+  for _, dl := range c.queued() {
+    if dl.tth != tth || dl.size < 0 || dl.total() != size { continue }
+    if dl.has(src.Nick) { continue }
+    dl.sources = append(dl.sources, src)
+    added = true
+    segmented = segmented || c.segmenting(dl)
+    if waiting[dl] && c.reroute(dl, "") {
+      retry = append(retry, dl)
+    }
+  }
+  c.Unlock()
+  if !added { return }
+
+  if segmented {
+    c.reach(src.Nick)
+  }
+  for _, dl := range retry {
+    if err := c.requeue(dl); err != nil {
+      c.log("couldn't download " + dl.file + " from " + dl.nick + ": " +
+            err.Error())
+    }
+  }
+  c.saveQueue()
+}
@@ -0,0 +1,84 @@
+package dc
+
+import "bufio"
+import "bytes"
+import "strings"
+import "testing"
+
+func Test_ParseResult(t *testing.T) {
+  src, size, tth, ok := parseResult([]byte("bar dir\\sub\\x\x0512 1/2\x05" +
+                                           "TTH:ABC (1.2.3.4:411)"))
+  if !ok || src != (Source{"bar", "dir/sub/x"}) || size != 12 ||
+     tth != "ABC" {
+    t.Error(src, size, tth, ok)
+  }
+
+  /* directories don't have a TTH */
+  _, _, _, ok = parseResult([]byte("bar dir\\sub 1/2\x05hub (1.2.3.4:411)"))
+  if ok { t.Error("directory accepted") }
+  _, _, _, ok = parseResult([]byte("bar x\x05huh 1/2\x05TTH:ABC (hub)"))
+  if ok { t.Error("bad size accepted") }
+}
+
+func Test_AlternateSources(t *testing.T) {
+  var buf bytes.Buffer
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet")
+  c.Lock()
+  c.Hub.write = bufio.NewWriter(&buf)
+  c.Hub.nicks["baz"] = &NickInfo{}
+  list := &FileListing{}
+  list.Files = []*File{{Name: "x", Size: 4, TTH: "T"}}
+  c.lists["baz"] = list
+  p := c.peers["bar"]
+  c.Unlock()
+
+  /* the source going away part way through moves the download over */
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4, TTH: "T"})
+  go c.download(dl)
+  var m method
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 4" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$ADCSND file f 0 4|ab")
+  _out.Close()
+  <-p.dead
+  waitQueued(t, c, dl)
+
+  c.Lock()
+  if dl.nick != "baz" || dl.file != "x" || dl.offset != 2 {
+    t.Error(dl.nick, dl.file, dl.offset)
+  }
+  if len(dl.sources) != 1 || dl.sources[0] != (Source{"bar", "f"}) {
+    t.Error(dl.sources)
+  }
+  c.Hub.write.Flush()
+  if !strings.Contains(buf.String(), "$Search Hub:foo F?T?0?9?TTH:T|") {
+    t.Error(buf.String())
+  }
+  if len(c.failed) != 0 { t.Error(c.failed) }
+  c.Unlock()
+  queue := c.Queue()
+  if len(queue) != 1 || len(queue[0].Sources) != 1 ||
+     queue[0].Sources[0] != "bar" {
+    t.Error(queue)
+  }
+
+  /* search results pick up downloads waiting to be retried */
+  dl2 := NewDownloadFile("gone", "/y", &File{Size: 7, TTH: "U"})
+  c.Lock()
+  c.identify(dl2)
+  c.fail(dl2, PeerGone)
+  c.Hub.nicks["quux"] = &NickInfo{}
+  c.Unlock()
+  parseMethod(&m, []byte("$SR quux dir\\y\x057 1/2\x05TTH:U (1.2.3.4:411)"))
+  c.hubExec(&m)
+  waitQueued(t, c, dl2)
+  c.Lock()
+  if dl2.file != "dir/y" || len(dl2.sources) != 1 ||
+     dl2.sources[0] != (Source{"gone", "y"}) {
+    t.Error(dl2.file, dl2.sources)
+  }
+  if len(c.failed) != 0 { t.Error(c.failed) }
+  c.Unlock()
+}
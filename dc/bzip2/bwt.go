@@ -0,0 +1,92 @@
+package bzip2
+
+/* Burrows-Wheeler transform of a block. The rotations of the block are sorted
+ * by prefix doubling: after each pass the rotations are ordered by their first
+ * 2k bytes, with a counting sort on the ranks from the previous pass. This is
+ * O(n log n) and, unlike the reference implementation, has no pathological
+ * inputs which need a fallback sort.
+ *
+ * Returns the last column of the sorted rotations along with the position of
+ * the original block in the sorted order. */
+func transform(block []byte) ([]byte, int) {
+  n := len(block)
+  sa := make([]int32, n)
+  rank := make([]int32, n)
+  tmp := make([]int32, n)
+  size := n
+  if size < 256 {
+    size = 256
+  }
+  cnt := make([]int32, size)
+
+  for _, b := range block {
+    cnt[b]++
+  }
+  for i := 1; i < 256; i++ {
+    cnt[i] += cnt[i - 1]
+  }
+  for i := n - 1; i >= 0; i-- {
+    cnt[block[i]]--
+    sa[cnt[block[i]]] = int32(i)
+  }
+  classes := int32(1)
+  rank[sa[0]] = 0
+  for i := 1; i < n; i++ {
+    if block[sa[i]] != block[sa[i - 1]] {
+      classes++
+    }
+    rank[sa[i]] = classes - 1
+  }
+
+  for k := 1; int(classes) < n && k < n; k <<= 1 {
+    /* Shifting the current order back by k sorts by the second half of each
+     * rotation, so a stable sort on the first half finishes the job */
+    for i, p := range sa {
+      p -= int32(k)
+      if p < 0 {
+        p += int32(n)
+      }
+      tmp[i] = p
+    }
+    for i := int32(0); i < classes; i++ {
+      cnt[i] = 0
+    }
+    for _, r := range rank {
+      cnt[r]++
+    }
+    for i := int32(1); i < classes; i++ {
+      cnt[i] += cnt[i - 1]
+    }
+    for i := n - 1; i >= 0; i-- {
+      p := tmp[i]
+      cnt[rank[p]]--
+      sa[cnt[rank[p]]] = p
+    }
+
+    second := func(p int32) int32 {
+      return rank[(int(p) + k) % n]
+    }
+    classes = 1
+    tmp[sa[0]] = 0
+    for i := 1; i < n; i++ {
+      cur, prev := sa[i], sa[i - 1]
+      if rank[cur] != rank[prev] || second(cur) != second(prev) {
+        classes++
+      }
+      tmp[cur] = classes - 1
+    }
+    rank, tmp = tmp, rank
+  }
+
+  out := make([]byte, n)
+  ptr := 0
+  for i, p := range sa {
+    if p == 0 {
+      ptr = i
+      out[i] = block[n - 1]
+    } else {
+      out[i] = block[p - 1]
+    }
+  }
+  return out, ptr
+}
@@ -0,0 +1,25 @@
+package bzip2
+
+/* bzip2 uses the big-endian flavor of CRC32, which hash/crc32 doesn't offer */
+
+var crcTable = func() (table [256]uint32) {
+  for i := range table {
+    crc := uint32(i) << 24
+    for j := 0; j < 8; j++ {
+      if crc & 0x80000000 != 0 {
+        crc = crc << 1 ^ 0x04c11db7
+      } else {
+        crc <<= 1
+      }
+    }
+    table[i] = crc
+  }
+  return
+}()
+
+/* Like crc32.Update, the running value is kept in its final form */
+func updateCRC(crc uint32, b byte) uint32 {
+  crc = ^crc
+  crc = crc << 8 ^ crcTable[byte(crc >> 24) ^ b]
+  return ^crc
+}
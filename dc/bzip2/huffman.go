@@ -0,0 +1,296 @@
+package bzip2
+
+import "sort"
+
+const (
+  runA = 0
+  runB = 1
+  groupSize = 50
+  maxCodeLen = 17
+  iterations = 4
+)
+
+/* Encodes the output of the BWT: the map of used bytes, the selectors and code
+ * lengths of the huffman tables, and then the symbols themselves. */
+func encode(b *bitWriter, data []byte) {
+  var inUse [256]bool
+  for _, c := range data {
+    inUse[c] = true
+  }
+  var seq [256]byte
+  nInUse := 0
+  for i, used := range inUse {
+    if used {
+      seq[i] = byte(nInUse)
+      nInUse++
+    }
+  }
+  alphaSize := nInUse + 2
+  syms := moveToFront(data, &seq, nInUse)
+
+  var groups uint64
+  for i := 0; i < 16; i++ {
+    for j := 0; j < 16; j++ {
+      if inUse[i * 16 + j] {
+        groups |= 1 << uint(15 - i)
+      }
+    }
+  }
+  b.write(16, groups)
+  for i := 0; i < 16; i++ {
+    if groups & (1 << uint(15 - i)) == 0 { continue }
+    var bits uint64
+    for j := 0; j < 16; j++ {
+      if inUse[i * 16 + j] {
+        bits |= 1 << uint(15 - j)
+      }
+    }
+    b.write(16, bits)
+  }
+
+  lengths, selectors := buildTables(syms, alphaSize)
+
+  b.write(3, uint64(len(lengths)))
+  b.write(15, uint64(len(selectors)))
+  var order [6]byte
+  for i := range order {
+    order[i] = byte(i)
+  }
+  for _, sel := range selectors {
+    j := 0
+    for order[j] != sel {
+      j++
+    }
+    copy(order[1:j + 1], order[:j])
+    order[0] = sel
+    for ; j > 0; j-- {
+      b.write(1, 1)
+    }
+    b.write(1, 0)
+  }
+
+  codes := make([][]uint32, len(lengths))
+  for t, lens := range lengths {
+    codes[t] = canonical(lens)
+    cur := lens[0]
+    b.write(5, uint64(cur))
+    for _, l := range lens {
+      for ; cur < l; cur++ {
+        b.write(2, 2)
+      }
+      for ; cur > l; cur-- {
+        b.write(2, 3)
+      }
+      b.write(1, 0)
+    }
+  }
+
+  for g, sel := range selectors {
+    end := (g + 1) * groupSize
+    if end > len(syms) {
+      end = len(syms)
+    }
+    for _, s := range syms[g * groupSize : end] {
+      b.write(uint(lengths[sel][s]), uint64(codes[sel][s]))
+    }
+  }
+}
+
+/* Move-to-front coding of the block, with runs of zeroes written in bijective
+ * base 2 using the RUNA and RUNB symbols. Every other value is shifted up by
+ * one, and the block is terminated by the end-of-block symbol. */
+func moveToFront(data []byte, seq *[256]byte, nInUse int) []uint16 {
+  syms := make([]uint16, 0, len(data) + 1)
+  var order [256]byte
+  for i := range order {
+    order[i] = byte(i)
+  }
+  zeroes := 0
+  flush := func() {
+    if zeroes == 0 { return }
+    zeroes--
+    for {
+      if zeroes & 1 != 0 {
+        syms = append(syms, runB)
+      } else {
+        syms = append(syms, runA)
+      }
+      if zeroes < 2 { break }
+      zeroes = (zeroes - 2) / 2
+    }
+    zeroes = 0
+  }
+
+  for _, c := range data {
+    s := seq[c]
+    if order[0] == s {
+      zeroes++
+      continue
+    }
+    flush()
+    j := 1
+    for order[j] != s {
+      j++
+    }
+    copy(order[1:j + 1], order[:j])
+    order[0] = s
+    syms = append(syms, uint16(j + 1))
+  }
+  flush()
+  return append(syms, uint16(nInUse + 1))
+}
+
+/* Picks code lengths for a set of huffman tables along with which table each
+ * group of 50 symbols uses. Tables start out covering disjoint ranges of the
+ * alphabet and are then refined by repeatedly assigning each group to its
+ * cheapest table and recomputing the lengths from the resulting frequencies. */
+func buildTables(syms []uint16, alphaSize int) ([][]uint8, []byte) {
+  nTables := 6
+  switch {
+    case len(syms) < 200:  nTables = 2
+    case len(syms) < 600:  nTables = 3
+    case len(syms) < 1200: nTables = 4
+    case len(syms) < 2400: nTables = 5
+  }
+  freqs := make([]int, alphaSize)
+  for _, s := range syms {
+    freqs[s]++
+  }
+
+  lengths := make([][]uint8, nTables)
+  remaining := len(syms)
+  start := 0
+  for part := nTables; part > 0; part-- {
+    target := remaining / part
+    end := start - 1
+    acc := 0
+    for acc < target && end < alphaSize - 1 {
+      end++
+      acc += freqs[end]
+    }
+    lens := make([]uint8, alphaSize)
+    for v := range lens {
+      if v < start || v > end {
+        lens[v] = 15
+      }
+    }
+    lengths[part - 1] = lens
+    start = end + 1
+    remaining -= acc
+  }
+
+  nGroups := (len(syms) + groupSize - 1) / groupSize
+  selectors := make([]byte, nGroups)
+  tableFreqs := make([][]int, nTables)
+  for t := range tableFreqs {
+    tableFreqs[t] = make([]int, alphaSize)
+  }
+  for iter := 0; iter < iterations; iter++ {
+    for t := range tableFreqs {
+      for v := range tableFreqs[t] {
+        tableFreqs[t][v] = 0
+      }
+    }
+    for g := range selectors {
+      end := (g + 1) * groupSize
+      if end > len(syms) {
+        end = len(syms)
+      }
+      group := syms[g * groupSize : end]
+      best, bestCost := 0, -1
+      for t, lens := range lengths {
+        cost := 0
+        for _, s := range group {
+          cost += int(lens[s])
+        }
+        if bestCost < 0 || cost < bestCost {
+          best, bestCost = t, cost
+        }
+      }
+      selectors[g] = byte(best)
+      for _, s := range group {
+        tableFreqs[best][s]++
+      }
+    }
+    for t := range lengths {
+      codeLengths(tableFreqs[t], lengths[t])
+    }
+  }
+  return lengths, selectors
+}
+
+/* Huffman code lengths for the given frequencies, limited to maxCodeLen bits
+ * by flattening the frequencies until the tree is shallow enough. Every symbol
+ * gets a code, even those which never appear. */
+func codeLengths(freqs []int, lengths []uint8) {
+  n := len(freqs)
+  weights := make([]int, 2 * n - 1)
+  for i, f := range freqs {
+    weights[i] = f
+    if weights[i] == 0 {
+      weights[i] = 1
+    }
+  }
+  order := make([]int, n)
+  parent := make([]int, 2 * n - 1)
+  depth := make([]int, 2 * n - 1)
+
+  for {
+    for i := range order {
+      order[i] = i
+    }
+    sort.SliceStable(order, func(a, b int) bool {
+      return weights[order[a]] < weights[order[b]]
+    })
+
+    /* Leaves come out of the sorted order, and internal nodes are created in
+     * increasing order of weight, so the two smallest are always at the front
+     * of one of the two queues */
+    leaf, node := 0, n
+    next := func(limit int) int {
+      if leaf < n && (node >= limit || weights[order[leaf]] <= weights[node]) {
+        leaf++
+        return order[leaf - 1]
+      }
+      node++
+      return node - 1
+    }
+    for i := n; i < 2 * n - 1; i++ {
+      a := next(i)
+      b := next(i)
+      weights[i] = weights[a] + weights[b]
+      parent[a], parent[b] = i, i
+    }
+
+    depth[2 * n - 2] = 0
+    tooLong := false
+    for i := 2 * n - 3; i >= 0; i-- {
+      depth[i] = depth[parent[i]] + 1
+      if i < n {
+        lengths[i] = uint8(depth[i])
+        tooLong = tooLong || depth[i] > maxCodeLen
+      }
+    }
+    if !tooLong { return }
+    for i := 0; i < n; i++ {
+      weights[i] = weights[i] / 2 + 1
+    }
+  }
+}
+
+/* Assigns codes in order of increasing length, and within a length in order of
+ * the symbols, like the reference implementation */
+func canonical(lengths []uint8) []uint32 {
+  codes := make([]uint32, len(lengths))
+  code := uint32(0)
+  for l := uint8(1); l <= maxCodeLen; l++ {
+    for s, sl := range lengths {
+      if sl == l {
+        codes[s] = code
+        code++
+      }
+    }
+    code <<= 1
+  }
+  return codes
+}
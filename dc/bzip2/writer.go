@@ -0,0 +1,168 @@
+package bzip2
+
+/* A bzip2 compressor, the standard library only provides decompression. The
+ * format is described in the comments of the reference implementation, and the
+ * stages are the same: an initial run-length encoding, the Burrows-Wheeler
+ * transform, move-to-front with run-length encoding of zeroes, and finally
+ * huffman coding with multiple tables. */
+
+import "bufio"
+import "errors"
+import "io"
+
+const blockMagic = 0x314159265359
+const finalMagic = 0x177245385090
+
+type Writer struct {
+  bits      *bitWriter
+  level     int
+  max       int
+  block     []byte
+  blockCRC  uint32
+  streamCRC uint32
+  run       byte
+  runLen    int
+  started   bool
+  closed    bool
+  err       error
+}
+
+var InvalidLevel = errors.New("bzip2: invalid compression level")
+var WriterClosed = errors.New("bzip2: writer is closed")
+
+/* Creates a writer with the maximum block size of 900k */
+func NewWriter(w io.Writer) *Writer {
+  z, _ := NewWriterLevel(w, 9)
+  return z
+}
+
+/* The level is the block size in units of 100k, from 1 to 9 */
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+  if level < 1 || level > 9 { return nil, InvalidLevel }
+  /* leave some slack like the reference implementation so a run is always
+   * able to fit at the end of a block */
+  max := level * 100000 - 19
+  return &Writer{bits:  &bitWriter{w: bufio.NewWriter(w)},
+                 level: level,
+                 max:   max,
+                 block: make([]byte, 0, max + 5)}, nil
+}
+
+func (z *Writer) Write(p []byte) (int, error) {
+  if z.closed { return 0, WriterClosed }
+  if z.err != nil { return 0, z.err }
+  z.header()
+  for _, b := range p {
+    if z.runLen > 0 && (b != z.run || z.runLen == 255) {
+      z.flushRun()
+    }
+    z.run = b
+    z.runLen++
+  }
+  return len(p), z.err
+}
+
+/* Finishes the stream, but doesn't close the underlying writer */
+func (z *Writer) Close() error {
+  if z.closed { return z.err }
+  z.closed = true
+  z.header()
+  if z.runLen > 0 {
+    z.flushRun()
+  }
+  if len(z.block) > 0 {
+    z.writeBlock()
+  }
+  z.bits.write(48, finalMagic)
+  z.bits.write(32, uint64(z.streamCRC))
+  z.bits.flush()
+  if z.err == nil {
+    z.err = z.bits.err
+  }
+  return z.err
+}
+
+func (z *Writer) header() {
+  if z.started { return }
+  z.started = true
+  z.bits.write(8, 'B')
+  z.bits.write(8, 'Z')
+  z.bits.write(8, 'h')
+  z.bits.write(8, uint64('0' + z.level))
+}
+
+/* Runs of 4 to 255 bytes are encoded as 4 bytes followed by a count of the
+ * remaining repetitions */
+func (z *Writer) flushRun() {
+  if len(z.block) + 5 > z.max {
+    z.writeBlock()
+  }
+  for i := 0; i < z.runLen; i++ {
+    z.blockCRC = updateCRC(z.blockCRC, z.run)
+  }
+  n := z.runLen
+  if n > 4 {
+    n = 4
+  }
+  for i := 0; i < n; i++ {
+    z.block = append(z.block, z.run)
+  }
+  if z.runLen >= 4 {
+    z.block = append(z.block, byte(z.runLen - 4))
+  }
+  z.runLen = 0
+}
+
+func (z *Writer) writeBlock() {
+  crc := z.blockCRC
+  z.streamCRC = (z.streamCRC << 1 | z.streamCRC >> 31) ^ crc
+
+  bwt, origPtr := transform(z.block)
+  z.bits.write(48, blockMagic)
+  z.bits.write(32, uint64(crc))
+  z.bits.write(1, 0) /* not randomized */
+  z.bits.write(24, uint64(origPtr))
+  encode(z.bits, bwt)
+
+  z.block = z.block[:0]
+  z.blockCRC = 0
+  if z.bits.err != nil {
+    z.err = z.bits.err
+  }
+}
+
+/* Bits are written most significant first */
+type bitWriter struct {
+  w     *bufio.Writer
+  acc   uint64
+  nbits uint
+  err   error
+}
+
+func (b *bitWriter) write(n uint, v uint64) {
+  for n > 0 {
+    amt := n
+    if amt > 32 {
+      amt = 32
+    }
+    n -= amt
+    b.acc = b.acc << amt | ((v >> n) & (1 << amt - 1))
+    b.nbits += amt
+    for b.nbits >= 8 {
+      b.nbits -= 8
+      if err := b.w.WriteByte(byte(b.acc >> b.nbits)); err != nil &&
+         b.err == nil {
+        b.err = err
+      }
+    }
+  }
+}
+
+func (b *bitWriter) flush() {
+  if b.nbits > 0 {
+    b.write(8 - b.nbits, 0)
+  }
+  if err := b.w.Flush(); err != nil && b.err == nil {
+    b.err = err
+  }
+}
@@ -0,0 +1,94 @@
+package bzip2
+
+import "testing"
+import "bytes"
+import "compress/bzip2"
+import "io/ioutil"
+import "math/rand"
+import "os/exec"
+import "strings"
+
+func compress(t *testing.T, data []byte, level int) []byte {
+  var buf bytes.Buffer
+  w, err := NewWriterLevel(&buf, level)
+  if err != nil { t.Fatal(err) }
+  /* write in uneven pieces to exercise runs spanning writes */
+  for i := 0; i < len(data); i += 1000 {
+    end := i + 1000
+    if end > len(data) { end = len(data) }
+    _, err = w.Write(data[i:end])
+    if err != nil { t.Fatal(err) }
+  }
+  err = w.Close()
+  if err != nil { t.Fatal(err) }
+  return buf.Bytes()
+}
+
+func roundtrip(t *testing.T, name string, data []byte, level int) {
+  out := compress(t, data, level)
+  back, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(out)))
+  if err != nil { t.Fatalf("%s: %v", name, err) }
+  if !bytes.Equal(back, data) {
+    t.Errorf("%s: got %d bytes back, expected %d", name, len(back), len(data))
+  }
+}
+
+func Test_RoundTrip(t *testing.T) {
+  random := make([]byte, 300000)
+  rand.New(rand.NewSource(1)).Read(random)
+  text := []byte(strings.Repeat("<File Name=\"foo\" Size=\"10\"/>\n", 20000))
+  runs := make([]byte, 0)
+  for i := 0; i < 2000; i++ {
+    runs = append(runs, bytes.Repeat([]byte{byte(i)}, i % 300)...)
+  }
+
+  roundtrip(t, "empty", []byte{}, 9)
+  roundtrip(t, "one", []byte("a"), 9)
+  roundtrip(t, "short", []byte("hello, world\n"), 9)
+  roundtrip(t, "zeroes", make([]byte, 100000), 9)
+  roundtrip(t, "periodic", bytes.Repeat([]byte("ab"), 5000), 9)
+  roundtrip(t, "runs", runs, 9)
+  roundtrip(t, "text", text, 9)
+  roundtrip(t, "random", random, 9)
+  /* several blocks */
+  roundtrip(t, "random blocks", random, 1)
+  roundtrip(t, "text blocks", text, 1)
+}
+
+func Test_Compresses(t *testing.T) {
+  text := []byte(strings.Repeat("<File Name=\"foo\" Size=\"10\"/>\n", 20000))
+  out := compress(t, text, 9)
+  if len(out) > len(text) / 100 { t.Error(len(out)) }
+}
+
+func Test_InvalidLevel(t *testing.T) {
+  if _, err := NewWriterLevel(ioutil.Discard, 0); err != InvalidLevel {
+    t.Error(err)
+  }
+  if _, err := NewWriterLevel(ioutil.Discard, 10); err != InvalidLevel {
+    t.Error(err)
+  }
+}
+
+func Test_WriteAfterClose(t *testing.T) {
+  w := NewWriter(ioutil.Discard)
+  if err := w.Close(); err != nil { t.Fatal(err) }
+  if _, err := w.Write([]byte("a")); err != WriterClosed { t.Error(err) }
+  if err := w.Close(); err != nil { t.Error(err) }
+}
+
+/* The reference implementation is pickier about its input than the standard
+ * library, so check against it when it's available */
+func Test_ReferenceDecoder(t *testing.T) {
+  path, err := exec.LookPath("bzip2")
+  if err != nil { t.Skip("no bzip2 binary") }
+  random := make([]byte, 200000)
+  rand.New(rand.NewSource(2)).Read(random)
+  data := append(random, bytes.Repeat([]byte("fargo "), 50000)...)
+
+  cmd := exec.Command(path, "-d", "-c")
+  cmd.Stdin = bytes.NewReader(compress(t, data, 2))
+  out, err := cmd.Output()
+  if err != nil { t.Fatal(err) }
+  if !bytes.Equal(out, data) { t.Error(len(out)) }
+}
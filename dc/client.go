@@ -12,6 +12,7 @@ import "regexp"
 import "strconv"
 import "strings"
 import "sync"
+import "time"
 
 type Client struct {
   /* configuration options */
@@ -23,6 +24,9 @@ type Client struct {
   UL            Slots
   DownloadRoot  string
   CacheDir      string
+  Template      string /* where downloads go under their root */
+  Conflicts     ConflictPolicy /* for downloads which don't pick their own */
+  SkipShared    bool /* don't download files which we're sharing already */
   Quiet         bool
   Hub           HubConnection
 
@@ -31,6 +35,11 @@ type Client struct {
   lists  map[string]*FileListing
   dls    map[string][]*download
   failed []*download
+  segmented []*download /* being fetched from several sources at once */
+  verifying []*download /* fetched and being hashed before they're done */
+  routes []Route
+  lastID uint64 /* of the last download queued */
+  turns  uint64 /* of the last download started */
   shares Shares
 
   sync.Mutex
@@ -72,6 +81,10 @@ type method struct {
 }
 
 var notConnected = errors.New("not connected to the Hub")
+var UserOffline = errors.New("user isn't on the hub")
+
+/* How long to wait for a peer to send the listing of a directory */
+var ListTimeout = 30 * time.Second
 var infoPattern = regexp.MustCompile(`\$ALL (\S*) (.*)\$ \$(.*)` + "\001?" +
                                      `\$(.*)\$(.*)\$`)
 var tagPattern = regexp.MustCompile(`(.*)<(.*) V:(.*),M:(.),H:\d+/\d+/\d+` +
@@ -113,6 +126,7 @@ func (h *HubConnection) parseInfo(info string) error {
 
 func NewClient() *Client {
   return &Client{Passive: true,
+                 SkipShared: true,
                  peers:   make(map[string]*peer),
                  lists:   make(map[string]*FileListing),
                  dls:     make(map[string][]*download),
@@ -259,6 +273,7 @@ func (c *Client) hubExec(m *method) {
   switch m.name {
   case "NickList":
     nicks := bytes.Split(m.data, []byte("$$"))
+    joined := make([]string, 0)
     c.Lock()
     for _, name := range nicks {
       nick := string(name)
@@ -267,28 +282,51 @@ func (c *Client) hubExec(m *method) {
         sendf(c.Hub.write, "GetINFO", func(w *bufio.Writer) {
           fmt.Fprintf(w, "%s %s", nick, c.Nick)
         })
+        joined = append(joined, nick)
       }
     }
     c.Unlock()
+    go func() {
+      for _, nick := range joined {
+        c.joined(nick)
+      }
+    }()
 
   case "Hello":
     nick := string(m.data)
     c.Lock()
-    if c.Hub.nicks[nick] == nil {
+    joined := c.Hub.nicks[nick] == nil
+    if joined {
       c.Hub.nicks[nick] = &NickInfo{}
     }
     c.Unlock()
+    if joined {
+      go c.joined(nick)
+    }
 
   case "Quit":
-    c.Lock()
-    delete(c.Hub.nicks, string(m.data))
-    c.Unlock()
+    c.left(string(m.data))
 
   case "MyINFO":
+    /* hubs which don't send $Hello announce new nicks with their info */
+    nick := ""
+    matches := infoPattern.FindStringSubmatch(string(m.data))
+    if len(matches) == 6 {
+      nick = matches[1]
+    }
+    c.Lock()
+    joined := nick != "" && c.Hub.nicks[nick] == nil
+    if joined {
+      c.Hub.nicks[nick] = &NickInfo{}
+    }
     err := c.Hub.parseInfo(string(m.data))
+    c.Unlock()
     if err != nil {
       c.log("MyINFO error: " + err.Error() + " for: " + string(m.data))
     }
+    if joined {
+      go c.joined(nick)
+    }
 
   case "HubName":
     if c.Hub.Name != string(m.data) {
@@ -319,6 +357,11 @@ func (c *Client) hubExec(m *method) {
       c.connect(remote)
     }
 
+  case "SR":
+    if src, size, tth, ok := parseResult(m.data); ok {
+      go c.foundSource(src, size, tth)
+    }
+
   case "ConnectToMe":
     parts := bytes.Split(m.data, []byte(" "))
     if len(parts) != 2 { break }
@@ -393,11 +436,33 @@ func (c *Client) connect(nick string) {
   })
 }
 
+/* Starts browsing a nick. Peers which support partial lists only send the
+ * root of their share, and the rest is fetched as it's looked at. */
 func (c *Client) Browse(nick string) error {
   if c.Hub.write == nil {
     return notConnected
   }
-  return c.download(NewDownload(nick, FileList))
+  return c.download(NewListDownload(nick, "/", false))
+}
+
+/* Fetches the listing of a directory from a peer and waits for it to be
+ * merged into what we know about their shares */
+func (c *Client) fetchList(nick, dir string, recursive bool) error {
+  /* there's no point waiting on someone who isn't around */
+  c.Lock()
+  reachable := c.reachable(nick)
+  c.Unlock()
+  if !reachable { return UserOffline }
+  dl := NewListDownload(nick, dir, recursive)
+  dl.done = make(chan error, 1)
+  err := c.download(dl)
+  if err != nil { return err }
+  select {
+    case err = <-dl.done:
+      return err
+    case <-time.After(ListTimeout):
+      return errors.New("timed out fetching the listing of " + dir)
+  }
 }
 
 func (c *Client) Nicks() []string {
@@ -438,7 +503,7 @@ func (c *Client) DisconnectHub() error {
   return c.Hub.conn.Close()
 }
 
-func (c *Client) Listings(nick string, dir string) (*Directory, error) {
+func (c *Client) listing(nick string) (*FileListing, error) {
   c.Lock()
   list := c.lists[nick]
   c.Unlock()
@@ -446,24 +511,89 @@ func (c *Client) Listings(nick string, dir string) (*Directory, error) {
   if list == nil {
     return nil, errors.New("No file list available for: " + nick)
   }
+  return list, nil
+}
+
+/* Returns the contents of a directory of a nick's shares, fetching it first
+ * if it hasn't been explored yet */
+func (c *Client) Listings(nick string, dir string) (*Directory, error) {
+  list, err := c.listing(nick)
+  if err != nil { return nil, err }
+  if list.unexplored(dir) {
+    err = c.fetchList(nick, dir, false)
+    if err != nil { return nil, err }
+    list, err = c.listing(nick)
+    if err != nil { return nil, err }
+  }
+  return list.FindDir(dir)
+}
+
+/* Like Listings, but never goes out to the network */
+func (c *Client) CachedListings(nick string, dir string) (*Directory, error) {
+  list, err := c.listing(nick)
+  if err != nil { return nil, err }
   return list.FindDir(dir)
 }
 
+/* How a download is carried out, the zero value uses the client's settings */
+type DownloadOptions struct {
+  Conflict ConflictPolicy
+  Priority Priority
+}
+
 func (c *Client) Download(nick string, pathname string) error {
+  return c.DownloadWith(nick, pathname, DownloadOptions{})
+}
+
+/* Downloads a file or directory, dealing with anything already at the
+ * destination according to the given policy */
+func (c *Client) DownloadWith(nick string, pathname string,
+                              opts DownloadOptions) error {
+  if opts.Conflict == ConflictDefault {
+    opts.Conflict = c.Conflicts
+  }
   extra, _ := path.Split(pathname)
-  c.Lock()
-  list := c.lists[nick]
-  c.Unlock()
-  if list == nil {
-    return errors.New("No file list available for: " + nick)
+  list, err := c.listing(nick)
+  if err != nil { return err }
+
+  /* Find out what's being downloaded, and then be sure that everything
+   * underneath a directory is known before queueing it up */
+  if list.unexplored(extra) {
+    err = c.fetchList(nick, extra, false)
+    if err == nil {
+      list, err = c.listing(nick)
+    }
+    if err != nil { return err }
+  }
+  if dir, err := list.FindDir(pathname); err == nil && dir.incomplete() {
+    err = c.fetchList(nick, pathname, true)
+    if err == nil {
+      list, err = c.listing(nick)
+    }
+    if err != nil { return err }
   }
-  return list.EachFile(pathname, func(f *File, path string) error {
+  err = list.EachFile(pathname, func(f *File, path string) error {
+    if c.sharing(f) {
+      c.log("Already sharing " + path[1:] + ", skipping it")
+      return nil
+    }
     dl := NewDownloadFile(nick, path, f)
-    dl.reldst = path[len(extra):]
+    c.route(dl, f, path, strings.TrimPrefix(path, extra))
+    dl.conflict = opts.Conflict
+    dl.priority = opts.Priority
     return c.download(dl)
   })
+  c.saveQueue()
+  return err
 }
 
+/* Whether a file in someone else's listing is one we're sharing already */
+func (c *Client) sharing(f *File) bool {
+  return c.SkipShared && f.TTH != "" && c.shares.query("TTH/" + f.TTH) != nil
+}
+
+/* Disconnects from everyone and saves the queue. Whatever was being downloaded
+ * stays queued for next time, like everything else, rather than failing. */
 func (c *Client) Stop() {
   c.DisconnectHub()
   c.shares.halt()
@@ -471,6 +601,12 @@ func (c *Client) Stop() {
   c.Lock()
   for _, peer := range c.peers {
     if peer == nil { continue }
+    peer.Lock()
+    peer.dropped = true
+    if peer.dl != nil && peer.dl.stop == nil {
+      peer.dl.stop = Stopping
+    }
+    peer.Unlock()
     if peer.in != nil {
       peer.in.Close()
       if peer.out != nil {
@@ -484,6 +620,7 @@ func (c *Client) Stop() {
   for _, p := range peers {
     <-p.dead
   }
+  c.saveQueue()
 }
 
 func (c *Client) Say(msg string) {
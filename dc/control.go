@@ -0,0 +1,252 @@
+package dc
+
+import "errors"
+import "os"
+import "sort"
+import "time"
+
+/* Downloads are referred to by their ID, which stays the same for as long as
+ * they're in the queue. Those which are in progress can't be stopped part way
+ * through a transfer by any of the ways of downloading, so the connection to
+ * the peer is dropped instead and whatever else is queued on it waits for a
+ * new one. */
+
+var Canceled = errors.New("download canceled")
+var Paused = errors.New("download paused")
+var Stopping = errors.New("client stopping")
+var UnknownDownload = errors.New("no such download")
+
+type DownloadState int
+
+const (
+  Queued DownloadState = iota
+  Active
+  Stopped /* paused */
+  Failed
+  Waiting /* for the nick to show up on the hub */
+)
+
+func (s DownloadState) String() string {
+  switch s {
+  case Active:  return "active"
+  case Stopped: return "paused"
+  case Failed:  return "failed"
+  case Waiting: return "waiting for user"
+  }
+  return "queued"
+}
+
+/* What's known about a download, as of when it was asked for */
+type QueuedDownload struct {
+  ID       uint64
+  Nick     string
+  File     string
+  Size     int64 /* -1 if unknown */
+  Done     int64
+  Priority Priority
+  State    DownloadState
+  Sources  []string /* other nicks it can be had from */
+
+  Failures int
+  Reason   string    /* why it last failed */
+  RetryAt  time.Time /* zero if it won't be retried on its own */
+}
+
+/* Everything which is queued up, being downloaded or failed, in the order it
+ * was queued */
+func (c *Client) Queue() []QueuedDownload {
+  c.Lock()
+  active := make(map[*download]bool)
+  for _, p := range c.peers {
+    p.Lock()
+    if p.dl != nil {
+      active[p.dl.item()] = true
+    }
+    p.Unlock()
+  }
+  for _, dl := range c.verifying {
+    active[dl] = true
+  }
+  failed := make(map[*download]bool)
+  for _, dl := range c.failed {
+    failed[dl] = true
+  }
+  dls := c.queued()
+  queue := make([]QueuedDownload, len(dls))
+  for i, dl := range dls {
+    state := Queued
+    if failed[dl] {
+      state = Failed
+    } else if active[dl] {
+      state = Active
+    } else if dl.priority == PriorityPaused {
+      state = Stopped
+    } else if !c.available(dl) {
+      state = Waiting
+    }
+    size := int64(-1)
+    if dl.size >= 0 {
+      size = dl.total()
+    }
+    queue[i] = QueuedDownload{ID: dl.id, Nick: dl.nick, File: dl.file,
+                              Size: size, Done: dl.fetched(),
+                              Priority: dl.priority, State: state,
+                              Failures: dl.failures, Reason: dl.reason}
+    for _, s := range dl.sources {
+      queue[i].Sources = append(queue[i].Sources, s.Nick)
+    }
+    if state == Failed && dl.timer != nil {
+      queue[i].RetryAt = dl.retryAt
+    }
+  }
+  c.Unlock()
+  sort.Slice(queue, func(i, j int) bool { return queue[i].ID < queue[j].ID })
+  return queue
+}
+
+/* Finds a download along with the peer it's queued on, which is nil if it
+ * failed or is being fetched in segments. The client must be locked. */
+func (c *Client) find(id uint64) (*peer, *download) {
+  for _, dl := range c.segmented {
+    if dl.id == id { return nil, dl }
+  }
+  for _, p := range c.peers {
+    p.Lock()
+    var found *download
+    if p.dl != nil && p.dl.id == id {
+      found = p.dl
+    }
+    for _, dl := range p.dls {
+      if dl.id == id {
+        found = dl
+      }
+    }
+    p.Unlock()
+    if found != nil { return p, found }
+  }
+  for _, dl := range c.verifying {
+    if dl.id == id { return nil, dl }
+  }
+  for _, dl := range c.failed {
+    if dl.id == id { return nil, dl }
+  }
+  return nil, nil
+}
+
+/* Hangs up on a peer to stop the download in progress. The peer must be
+ * locked. */
+func (p *peer) drop(dl *download, reason error) {
+  dl.stop = reason
+  p.dropped = true
+  if p.in != nil {
+    p.in.Close()
+  }
+  if p.out != nil {
+    p.out.Close()
+  }
+}
+
+/* Takes a download out of the queue, stopping it if it's in progress. What's
+ * been downloaded so far is kept unless it's to be deleted. */
+func (c *Client) CancelDownload(id uint64, purge bool) error {
+  c.Lock()
+  p, dl := c.find(id)
+  if dl == nil {
+    c.Unlock()
+    return UnknownDownload
+  }
+  if c.unsegment(dl, Canceled) {
+    /* the last of its segments to stop cleans up after it */
+    dl.purge = purge
+    if len(dl.parts.active) > 0 {
+      c.Unlock()
+      dl.finish(Canceled)
+      return nil
+    }
+  } else if p == nil && c.stopVerify(dl) {
+    /* the check of it going on cleans up after it */
+    dl.purge = purge
+    c.Unlock()
+    dl.finish(Canceled)
+    c.saveQueue()
+    return nil
+  } else if p == nil {
+    for i, f := range c.failed {
+      if f == dl {
+        c.failed = append(c.failed[:i], c.failed[i+1:]...)
+        break
+      }
+    }
+    if dl.timer != nil {
+      dl.timer.Stop()
+      dl.timer = nil
+    }
+  } else {
+    p.Lock()
+    if p.dl == dl {
+      /* the peer going away cleans up after the download */
+      dl.purge = purge
+      p.drop(dl, Canceled)
+      p.Unlock()
+      c.Unlock()
+      return nil
+    }
+    p.remove(dl)
+    p.Unlock()
+  }
+  c.Unlock()
+
+  if purge && dl.dst != "" {
+    os.Remove(dl.temp())
+  }
+  dl.finish(Canceled)
+  c.saveQueue()
+  return nil
+}
+
+/* Keeps a download from being started until it's resumed, stopping it if
+ * it's in progress */
+func (c *Client) PauseDownload(id uint64) error {
+  c.Lock()
+  p, dl := c.find(id)
+  if dl == nil {
+    c.Unlock()
+    return UnknownDownload
+  }
+  if dl.priority != PriorityPaused {
+    dl.unpaused = dl.priority
+    dl.priority = PriorityPaused
+  }
+  /* what's left of a download being fetched in segments is queued up again,
+   * and its segments hand back what they didn't get to as they stop */
+  segmented := c.unsegment(dl, Paused)
+  if p != nil {
+    p.Lock()
+    if p.dl == dl {
+      p.drop(dl, Paused)
+    }
+    p.Unlock()
+  }
+  c.Unlock()
+  if segmented {
+    if err := c.download(dl); err != nil { return err }
+  }
+  c.saveQueue()
+  return nil
+}
+
+/* Lets a paused download be started again with the priority it had */
+func (c *Client) ResumeDownload(id uint64) error {
+  c.Lock()
+  _, dl := c.find(id)
+  if dl == nil {
+    c.Unlock()
+    return UnknownDownload
+  }
+  if dl.priority == PriorityPaused {
+    dl.priority = dl.unpaused
+  }
+  c.Unlock()
+  c.saveQueue()
+  return c.initiateDownload()
+}
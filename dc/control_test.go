@@ -0,0 +1,131 @@
+package dc
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+func Test_QueueControl(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  c := NewClient()
+  c.Quiet = true
+  c.CacheDir = wd
+
+  a := &download{nick: "foo", file: "a", size: 10, reldst: "a",
+                 dst: filepath.Join(wd, "a")}
+  err := ioutil.WriteFile(a.temp(), []byte("0123"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  b := NewDownload("bar", "b")
+  for _, dl := range []*download{a, b} {
+    if err := c.download(dl); err != nil { t.Fatal(err) }
+  }
+  if a.id == 0 || b.id <= a.id { t.Fatal(a.id, b.id) }
+
+  queue := c.Queue()
+  if len(queue) != 2 { t.Fatal(queue) }
+  if queue[0].ID != a.id || queue[0].Size != 10 ||
+     queue[0].State != Waiting {
+    t.Error(queue[0])
+  }
+  if queue[1].ID != b.id || queue[1].Size != -1 { t.Error(queue[1]) }
+
+  /* pausing remembers the priority to go back to */
+  b.priority = PriorityHigh
+  if err := c.PauseDownload(b.id); err != nil { t.Fatal(err) }
+  if c.Queue()[1].State != Stopped { t.Error(c.Queue()[1]) }
+  if err := c.ResumeDownload(b.id); err != nil { t.Fatal(err) }
+  if b.priority != PriorityHigh { t.Error(b.priority) }
+
+  /* IDs outlive restarts, and aren't handed out again */
+  c = NewClient()
+  c.CacheDir = wd
+  if err := c.LoadQueue(); err != nil { t.Fatal(err) }
+  queue = c.Queue()
+  if len(queue) != 2 || queue[0].ID != a.id || queue[1].ID != b.id {
+    t.Fatal(queue)
+  }
+  if queue[0].Done != 4 { t.Error(queue[0]) }
+  c2 := NewDownload("baz", "c")
+  if err := c.download(c2); err != nil { t.Fatal(err) }
+  if c2.id <= b.id { t.Error(c2.id) }
+
+  if err := c.CancelDownload(a.id, true); err != nil { t.Fatal(err) }
+  if _, err := os.Stat(a.temp()); err == nil { t.Error() }
+  if err := c.CancelDownload(a.id, true); err != UnknownDownload {
+    t.Error(err)
+  }
+  if err := c.PauseDownload(a.id); err != UnknownDownload { t.Error(err) }
+  if len(c.Queue()) != 2 { t.Error(c.Queue()) }
+}
+
+/* Stops a download part way through with another queued up behind it, and
+ * then checks on how they were left */
+func stopInFlight(t *testing.T, stop func(*Client, *download) error,
+                  check func(*Client, *download, *download)) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet")
+
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4})
+  dl.done = make(chan error, 1)
+  other := NewDownloadFile("bar", "/g", &File{Size: 4})
+  go c.download(dl)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 4" { t.Fatal(string(m.data)) }
+  if err := c.download(other); err != nil { t.Fatal(err) }
+  xsend(t, out, "$ADCSND file f 0 4|ab")
+
+  c.Lock()
+  p := c.peers["bar"]
+  c.Unlock()
+  if err := stop(c, dl); err != nil { t.Fatal(err) }
+  <-p.dead
+
+  /* the slot is free again and the rest of the queue is left alone */
+  if c.DL.Cnt != 1 { t.Error(c.DL.Cnt) }
+  if len(c.failed) != 0 { t.Error(c.failed) }
+  c.Lock()
+  if c.peers["bar"] == nil || c.peers["bar"] == p { t.Error(c.peers) }
+  c.Unlock()
+  check(c, dl, other)
+}
+
+/* Downloads in progress are stopped by hanging up on the peer */
+func Test_CancelInFlight(t *testing.T) {
+  stop := func(c *Client, dl *download) error {
+    return c.CancelDownload(dl.id, true)
+  }
+  stopInFlight(t, stop, func(c *Client, dl, other *download) {
+    if err := <-dl.done; err != Canceled { t.Error(err) }
+    if _, err := os.Stat(dl.temp()); err == nil { t.Error() }
+    queue := c.Queue()
+    if len(queue) != 1 || queue[0].ID != other.id ||
+       queue[0].State != Waiting {
+      t.Error(queue)
+    }
+  })
+}
+
+func Test_PauseInFlight(t *testing.T) {
+  stop := func(c *Client, dl *download) error {
+    return c.PauseDownload(dl.id)
+  }
+  stopInFlight(t, stop, func(c *Client, dl, other *download) {
+    select {
+    case err := <-dl.done: t.Error(err)
+    default:
+    }
+    /* whatever made it to disk is kept for later */
+    if _, err := os.Stat(dl.temp()); err != nil { t.Error(err) }
+    queue := c.Queue()
+    if len(queue) != 2 { t.Fatal(queue) }
+    if queue[0].ID != dl.id || queue[0].State != Stopped { t.Error(queue[0]) }
+    if queue[1].ID != other.id || queue[1].State != Waiting {
+      t.Error(queue[1])
+    }
+    if err := c.ResumeDownload(dl.id); err != nil { t.Fatal(err) }
+    if c.Queue()[0].State != Waiting { t.Error(c.Queue()[0]) }
+  })
+}
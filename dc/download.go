@@ -1,33 +1,108 @@
 package dc
 
+import "bytes"
+import "errors"
 import "fmt"
+import "io"
 import "os"
 import "path/filepath"
+import "strings"
+import "time"
 
 type download struct {
   nick   string
   file   string
   tth    string
   offset int64
-  size   int64
+  size   int64  /* how much is left to download, from the offset */
+  root   string /* instead of the download root */
   reldst string
+  dst    string /* where it's being downloaded to, once it's started */
+  conflict ConflictPolicy
+  priority Priority
+  id       uint64 /* stays the same across restarts, earlier ones go first */
+  unpaused Priority /* what it had before it was paused */
+  stop     error /* why the connection it's downloading over was dropped */
+  purge    bool /* whether partial data goes too when it's canceled */
+
+  /* failed downloads are tried again after a while */
+  failures int
+  reason   string /* of the last failure */
+  retryAt  time.Time
+  timer    *time.Timer
+
+  /* files with a TTH can also be fetched from others sharing them, a segment
+   * from each, see segment.go */
+  sources []Source
+  parts   *parts    /* set once it's split up */
+  parent  *download /* of a segment, the download it's a part of */
+  seg     *segment
+
+  /* partial file lists are of one directory, possibly with its subtree */
+  dir       string
+  recursive bool
+  done      chan error
+
+  /* blocks are verified against the leaves of the file's tree, if the peer
+   * could send them, and corrupt blocks are queued up to be fetched again */
+  leaves     []byte
+  block      int64
+  bad        []int64
+  corrupt    int
+  unverified bool
+  attempts   int /* how many times the whole file has come out corrupt */
 }
 
 const FileList = "files.xml.bz2"
 
+/* Downloads are written to a file with this suffix next to their destination,
+ * and only renamed into place once they're complete */
+const TempSuffix = ".dctmp"
+
+/* What to do when something is already at a download's destination. Resuming
+ * treats the existing file as the first part of the download. */
+type ConflictPolicy int
+
+const (
+  ConflictDefault ConflictPolicy = iota /* whatever the client is set to */
+  ConflictRename
+  ConflictOverwrite
+  ConflictSkip
+  ConflictResume
+)
+
+var AlreadyExists = errors.New("destination already exists")
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+  switch s {
+  case "rename":    return ConflictRename, nil
+  case "overwrite": return ConflictOverwrite, nil
+  case "skip":      return ConflictSkip, nil
+  case "resume":    return ConflictResume, nil
+  }
+  return ConflictDefault, errors.New("unknown conflict policy: " + s)
+}
+
+func (p ConflictPolicy) String() string {
+  switch p {
+  case ConflictOverwrite: return "overwrite"
+  case ConflictSkip:      return "skip"
+  case ConflictResume:    return "resume"
+  }
+  return "rename"
+}
+
 func (c *Client) download(dl *download) error {
   if dl == nil { panic("can't download nil") }
-  p := c.peer(dl.nick, func(p *peer) {
-    if p.state != Uninitialized {
-      return
-    }
-    if c.Passive {
-      c.recvconnect(dl.nick)
-    } else {
-      c.connect(dl.nick)
-    }
-    p.state = RequestingConnection
-  })
+  c.Lock()
+  c.identify(dl)
+  if dl.fetchedAll() {
+    c.startVerify(dl)
+    c.Unlock()
+    return nil
+  }
+  c.Unlock()
+  p := c.reach(dl.nick)
 
   p.Lock()
   p.push(dl)
@@ -35,16 +110,174 @@ func (c *Client) download(dl *download) error {
   return c.initiateDownload()
 }
 
+/* Asks the hub for a connection to a peer. Without a hub the request is made
+ * once one is connected to. */
+func (c *Client) requestConnection(p *peer) {
+  if p.state != Uninitialized || c.Hub.write == nil {
+    return
+  }
+  if c.Passive {
+    c.recvconnect(p.nick)
+  } else {
+    c.connect(p.nick)
+  }
+  p.state = RequestingConnection
+}
+
 func (d *download) fileList() bool {
   return d.file == FileList
 }
 
+/* The size of the whole file, downloads may start part way through */
+func (d *download) total() int64 {
+  return d.offset + d.size
+}
+
+/* The size of the whole file, even for a segment of it */
+func (d *download) length() int64 {
+  if d.parent != nil { return d.parent.total() }
+  return d.total()
+}
+
+/* The download a segment is a part of, or the download itself */
+func (d *download) item() *download {
+  if d.parent != nil { return d.parent }
+  return d
+}
+
+func (d *download) temp() string {
+  return d.dst + TempSuffix
+}
+
+/* Opens the temporary file to download into. A resumed download carries on
+ * with what's already there, as do segments and the downloads split into
+ * them, and anything else starts from scratch. */
+func (d *download) open(root string) (*os.File, error) {
+  if d.dst == "" || d.fileList() {
+    dst, err := d.destination(root)
+    if err != nil { return nil, err }
+    d.dst = dst
+    if d.conflict == ConflictResume {
+      d.adopt()
+    }
+  }
+  if d.offset > 0 || d.parts != nil || d.parent != nil {
+    return os.OpenFile(d.temp(), os.O_RDWR | os.O_CREATE, os.FileMode(0644))
+  }
+  return os.Create(d.temp())
+}
+
+/* Takes a copy of a file already at the destination as the start of the
+ * download. The check of the finished file catches it if it wasn't, and the
+ * original is left where it is either way until the download replaces it. */
+func (d *download) adopt() {
+  src, err := os.Open(d.dst)
+  if err != nil { return }
+  defer src.Close()
+  dst, err := os.Create(d.temp())
+  if err != nil { return }
+  _, err = io.Copy(dst, src)
+  dst.Close()
+  if err != nil {
+    os.Remove(d.temp())
+    return
+  }
+  d.size = d.total()
+  d.offset = 0
+  d.resumeFrom(d.temp())
+}
+
+/* Whether the file at one path is the start of the one at another */
+func prefixOf(path string, of string) bool {
+  a, err := os.Open(path)
+  if err != nil { return false }
+  defer a.Close()
+  b, err := os.Open(of)
+  if err != nil { return false }
+  defer b.Close()
+  abuf, bbuf := make([]byte, 32 << 10), make([]byte, 32 << 10)
+  for {
+    n, err := io.ReadFull(a, abuf)
+    if err == io.EOF { return true }
+    if err != nil && err != io.ErrUnexpectedEOF { return false }
+    if _, err := io.ReadFull(b, bbuf[:n]); err != nil { return false }
+    if !bytes.Equal(abuf[:n], bbuf[:n]) { return false }
+    if n < len(abuf) { return true }
+  }
+}
+
+/* Moves a finished download into place. If something else has shown up at
+ * the destination in the meantime, another name is picked unless it's meant
+ * to be overwritten, or the download was resumed and it's only the start of
+ * what was fetched. */
+func (d *download) commit(root string) error {
+  tmp, dst := d.temp(), d.dst
+  _, err := os.Stat(dst)
+  if err == nil && !d.fileList() && d.conflict != ConflictOverwrite &&
+     !(d.conflict == ConflictResume && prefixOf(dst, tmp)) {
+    dst, err = d.pickName(root, ConflictRename)
+    if err != nil { return err }
+  }
+  /* the temporary file is only found through the destination */
+  if err = os.Rename(tmp, dst); err != nil { return err }
+  d.dst = dst
+  return nil
+}
+
+/* Moves the start of a download up to what has been written to disk so far,
+ * stopping short of any blocks which still need to be fetched again */
+func (d *download) resumeFrom(path string) {
+  if d.size < 0 { return }
+  done := int64(0)
+  if info, err := os.Stat(path); err == nil {
+    done = info.Size()
+  }
+  for _, i := range d.bad {
+    if start, _ := d.segment(i); start < done {
+      done = start
+    }
+  }
+  if done > d.total() {
+    done = d.total()
+  }
+  d.size = d.total() - done
+  d.offset = done
+  d.bad = nil
+  d.corrupt = 0
+}
+
+/* Lets anyone waiting on this download know how it went */
+func (d *download) finish(err error) {
+  if d.done == nil { return }
+  select {
+    case d.done <- err:
+    default:
+  }
+}
+
 func (d *download) destination(root string) (string, error) {
+  return d.pickName(root, d.conflict)
+}
+
+func (d *download) pickName(root string,
+                            policy ConflictPolicy) (string, error) {
+  if d.root != "" {
+    root = d.root
+  }
   root, err := filepath.Abs(root)
   if err != nil {
     return "", err
   }
-  path := filepath.Clean(filepath.Join(root, d.reldst))
+  /* nothing in the name may have been usable, but it still needs one */
+  rel := SafePath(d.reldst)
+  if rel == "" {
+    rel = "_"
+  }
+  path := filepath.Join(root, rel)
+  if rel, err := filepath.Rel(root, path); err != nil || rel == "." ||
+     rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+    return "", errors.New("download escapes the download root: " + d.reldst)
+  }
   dir, file := filepath.Split(path)
   err = os.MkdirAll(dir, os.ModeDir | os.FileMode(0755))
   if err != nil {
@@ -53,24 +286,49 @@ func (d *download) destination(root string) (string, error) {
 
   /* file lists are special in that they are overwritten frequently */
   if d.fileList() {
-    file = d.nick + "-" + file
+    file = SafeName(d.nick) + "-" + file
     path := filepath.Join(dir, file)
     os.Remove(path) // ignore error
     return path, nil
   }
 
+  /* names which other downloads are in the middle of using are taken */
+  taken := func(path string) bool {
+    if _, err := os.Stat(path + TempSuffix); err == nil {
+      return true
+    }
+    _, err := os.Stat(path)
+    return err == nil
+  }
+  _, busy := os.Stat(path + TempSuffix)
+  if info, err := os.Stat(path); err == nil && busy != nil {
+    switch policy {
+    case ConflictOverwrite:
+      return path, nil
+    case ConflictSkip:
+      return "", AlreadyExists
+    case ConflictResume:
+      /* without a TTH there'd be no telling whether it really was a prefix */
+      if d.tth != "" && d.size >= 0 && info.Size() <= d.total() {
+        return path, nil
+      }
+    }
+  }
+  if policy == ConflictSkip && taken(path) {
+    return "", AlreadyExists
+  }
+
   tries, suffix := 0, ""
   ext := filepath.Ext(file)
   filebase := file[0:len(file)-len(ext)]
   for {
     path = filepath.Join(dir, filebase + suffix + ext)
-    _, err := os.Stat(path)
-    if err != nil {
+    if !taken(path) {
       break
     }
     tries++
-    if tries > 100 {
-      panic("your file system is wack")
+    if tries > 1000 {
+      return "", errors.New("no free name for " + path)
     }
     suffix = fmt.Sprintf("-%d", tries)
   }
@@ -81,6 +339,20 @@ func NewDownload(nick string, file string) *download {
   return &download{nick: nick, file: file, size: -1, reldst: file}
 }
 
+/* A download of the listing of a single directory, falling back to the whole
+ * file list if the peer doesn't support partial lists */
+func NewListDownload(nick string, dir string, recursive bool) *download {
+  dir = strings.Trim(dir, "/")
+  if dir == "" {
+    dir = "/"
+  } else {
+    dir = "/" + dir + "/"
+  }
+  /* someone's usually waiting to browse, so lists go before anything else */
+  return &download{nick: nick, file: FileList, size: -1, reldst: "files.xml",
+                   dir: dir, recursive: recursive, priority: PriorityHighest}
+}
+
 func NewDownloadFile(nick string, path string, file *File) *download {
   return &download{nick: nick, file: path[1:], size: int64(file.Size),
                    tth: file.TTH, reldst: path}
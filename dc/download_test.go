@@ -3,7 +3,10 @@ package dc
 import "testing"
 import "io/ioutil"
 import "os"
+import "path"
 import "path/filepath"
+import "strings"
+import "unicode/utf8"
 
 func Test_DownloadDestinations(t *testing.T) {
   dl := NewDownload("foo", "path/to/file")
@@ -41,3 +44,234 @@ func Test_DownloadDestinations(t *testing.T) {
   if err != nil { t.Error(err) }
   if dst != wd + "/a/to/file-1.ext" { t.Error(dst) }
 }
+
+/* Downloads only show up under their real name once they're complete */
+func Test_DownloadTempFiles(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  dl := NewDownload("foo", "file.ext")
+  f, err := dl.open(wd)
+  if err != nil { t.Fatal(err) }
+  f.Close()
+  if f.Name() != wd + "/file.ext" + TempSuffix { t.Error(f.Name()) }
+  if _, err := os.Stat(wd + "/file.ext"); err == nil { t.Error() }
+
+  /* names of downloads in progress aren't handed out again */
+  other := NewDownload("bar", "file.ext")
+  dst, err := other.destination(wd)
+  if err != nil { t.Fatal(err) }
+  if dst != wd + "/file-1.ext" { t.Error(dst) }
+
+  /* something taking the name in the meantime gets another one picked */
+  err = ioutil.WriteFile(wd + "/file.ext", []byte("a"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  err = dl.commit(wd)
+  if err != nil { t.Fatal(err) }
+  if dl.dst != wd + "/file-1.ext" { t.Error(dl.dst) }
+  if _, err := os.Stat(dl.dst); err != nil { t.Error(err) }
+  if _, err := os.Stat(wd + "/file.ext" + TempSuffix); err == nil { t.Error() }
+}
+
+func Test_SafeNames(t *testing.T) {
+  cases := map[string]string{
+    "normal.txt":         "normal.txt",
+    "..":                 "_",
+    ".":                  "_",
+    "a/b":                "a_b",
+    `a\b`:                "a_b",
+    "nul\x00byte":        "nul_byte",
+    "bell\x07\x7f":       "bell__",
+    `what?<>:"|*`:        "what_______",
+    "trailing. . ":       "trailing",
+    "CON":                "_CON",
+    "com1.txt":           "_com1.txt",
+    "console":            "console",
+    "bad\xffutf8":        "bad_utf8",
+    "ünïcödé":            "ünïcödé",
+    ".hidden":            ".hidden",
+  }
+  for in, expected := range cases {
+    if out := SafeName(in); out != expected {
+      t.Errorf("%q became %q, not %q", in, out, expected)
+    }
+  }
+
+  long := SafeName(strings.Repeat("é", 200) + ".mkv")
+  if len(long) > MaxNameLength || !strings.HasSuffix(long, ".mkv") {
+    t.Error(len(long), long[len(long) - 10:])
+  }
+  if !utf8.ValidString(long) { t.Error("split a character") }
+}
+
+func Test_SafePaths(t *testing.T) {
+  cases := map[string]string{
+    "a/b/c":                  "a/b/c",
+    "/etc/passwd":            "etc/passwd",
+    "../../etc/passwd":       "etc/passwd",
+    "a/../../b":              "a/b",
+    `..\..\windows\system32`: "windows/system32",
+    "a//./b":                 "a/b",
+    "C:/boot.ini":            "C_/boot.ini",
+    "../..":                  "",
+  }
+  for in, expected := range cases {
+    if out := SafePath(in); out != expected {
+      t.Errorf("%q became %q, not %q", in, out, expected)
+    }
+  }
+}
+
+/* Listings from malicious peers can't get files written outside of the
+ * download root */
+func Test_HostileListings(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  root := filepath.Join(wd, "downloads")
+
+  list := `<?xml version="1.0" encoding="UTF-8"?>
+    <FileListing Base="/" Version="1" Generator="evil">
+      <Directory Name="..">
+        <File Name="../../escape" Size="1" TTH="a"/>
+        <Directory Name="../..">
+          <File Name="deeper" Size="1" TTH="b"/>
+        </Directory>
+      </Directory>
+      <Directory Name="share">
+        <File Name="/etc/passwd" Size="1" TTH="c"/>
+        <File Name="..\..\win" Size="1" TTH="d"/>
+        <File Name="tab&#9;ctl" Size="1" TTH="e"/>
+        <File Name=".." Size="1" TTH="f"/>
+        <File Name="" Size="1" TTH="g"/>
+        <File Name="` + strings.Repeat("x", 1000) + `" Size="1" TTH="h"/>
+      </Directory>
+    </FileListing>`
+  var listing FileListing
+  err := ParseFileList(strings.NewReader(list), &listing)
+  if err != nil { t.Fatal(err) }
+
+  for _, start := range []string{"/", "/share", "/..", "/share/"} {
+    extra, _ := path.Split(start)
+    count := 0
+    err = listing.EachFile(start, func(f *File, pathname string) error {
+      dl := NewDownloadFile("evil", pathname, f)
+      dl.reldst = strings.TrimPrefix(pathname, extra)
+      dst, err := dl.destination(root)
+      if err != nil { return err }
+      rel, err := filepath.Rel(root, dst)
+      if err != nil || strings.HasPrefix(rel, "..") || rel == "." {
+        t.Errorf("%q ends up at %q", pathname, dst)
+      }
+      for _, part := range strings.Split(rel, string(filepath.Separator)) {
+        if len(part) > MaxNameLength { t.Errorf("%q is too long", part) }
+      }
+      count++
+      return nil
+    })
+    if err != nil { t.Error(start, err) }
+    if count == 0 { t.Error(start, "found nothing") }
+  }
+
+  /* nicks are used for names too */
+  dl := NewListDownload("../../evil", "/", false)
+  dst, err := dl.destination(root)
+  if err != nil { t.Fatal(err) }
+  if filepath.Dir(dst) != root { t.Error(dst) }
+}
+
+func Test_ConflictPolicies(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  err := ioutil.WriteFile(wd + "/file.ext", []byte("abc"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  file := &File{Name: "file.ext", Size: 6, TTH: "ABC"}
+
+  for name, want := range map[string]string{"rename":    "/file-1.ext",
+                                             "overwrite": "/file.ext",
+                                             "resume":    "/file.ext"} {
+    policy, err := ParseConflictPolicy(name)
+    if err != nil { t.Fatal(err) }
+    if policy.String() != name { t.Error(policy) }
+    dl := NewDownloadFile("foo", "/file.ext", file)
+    dl.conflict = policy
+    dst, err := dl.destination(wd)
+    if err != nil { t.Error(name, err) }
+    if dst != wd + want { t.Error(name, dst) }
+  }
+  if _, err := ParseConflictPolicy("wat"); err == nil { t.Error() }
+
+  dl := NewDownloadFile("foo", "/file.ext", file)
+  dl.conflict = ConflictSkip
+  if _, err := dl.open(wd); err != AlreadyExists { t.Error(err) }
+
+  /* a file which is bigger than the download can't be a prefix of it */
+  big := NewDownloadFile("foo", "/file.ext", &File{Size: 2, TTH: "ABC"})
+  big.conflict = ConflictResume
+  dst, err := big.destination(wd)
+  if err != nil { t.Error(err) }
+  if dst != wd + "/file-1.ext" { t.Error(dst) }
+
+  /* resuming picks up from the end of what's there */
+  dl = NewDownloadFile("foo", "/file.ext", file)
+  dl.conflict = ConflictResume
+  f, err := dl.open(wd)
+  if err != nil { t.Fatal(err) }
+  f.Close()
+  if dl.offset != 3 || dl.size != 3 { t.Error(dl.offset, dl.size) }
+  data, err := ioutil.ReadFile(wd + "/file.ext")
+  if err != nil || string(data) != "abc" { t.Error(string(data), err) }
+
+  /* canceling it and deleting what's been fetched leaves the original */
+  os.Remove(dl.temp())
+  data, err = ioutil.ReadFile(wd + "/file.ext")
+  if err != nil || string(data) != "abc" { t.Error(string(data), err) }
+
+  /* the finished file only replaces the original if it starts with it */
+  dl = NewDownloadFile("foo", "/file.ext", file)
+  dl.conflict = ConflictResume
+  f, err = dl.open(wd)
+  if err != nil { t.Fatal(err) }
+  f.WriteAt([]byte("def"), 3)
+  f.Close()
+  if err = dl.commit(wd); err != nil { t.Fatal(err) }
+  if dl.dst != wd + "/file.ext" { t.Error(dl.dst) }
+  data, err = ioutil.ReadFile(wd + "/file.ext")
+  if err != nil || string(data) != "abcdef" { t.Error(string(data), err) }
+  other := NewDownloadFile("foo", "/file.ext", &File{Size: 7, TTH: "ABC"})
+  other.conflict = ConflictResume
+  f, err = other.open(wd)
+  if err != nil { t.Fatal(err) }
+  f.WriteAt([]byte("xbcdefg"), 0)
+  f.Close()
+  if err = other.commit(wd); err != nil { t.Fatal(err) }
+  if other.dst != wd + "/file-1.ext" { t.Error(other.dst) }
+
+  /* and overwriting replaces whatever turned up in the meantime */
+  err = ioutil.WriteFile(wd + "/file.ext", []byte("a"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile(dl.temp(), []byte("abc"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  dl.conflict = ConflictOverwrite
+  err = dl.commit(wd)
+  if err != nil { t.Fatal(err) }
+  data, err = ioutil.ReadFile(wd + "/file.ext")
+  if err != nil { t.Fatal(err) }
+  if string(data) != "abc" { t.Error(string(data)) }
+}
+
+/* Files we're already sharing aren't downloaded again */
+func Test_SkipShared(t *testing.T) {
+  c := NewClient()
+  c.Quiet = true
+  c.DownloadRoot = tmpdir(t)
+  defer os.RemoveAll(c.DownloadRoot)
+  stub_file(t, c)
+  defer c.shares.halt()
+  c.shares.queryWait("foo") /* wait for tth hashes to propogate */
+
+  f := &File{Name: "x", Size: 4, TTH: "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI"}
+  if !c.sharing(f) { t.Error() }
+  if c.sharing(&File{Name: "x", Size: 4, TTH: "ABC"}) { t.Error() }
+  if c.sharing(&File{Name: "x", Size: 4}) { t.Error() }
+  c.SkipShared = false
+  if c.sharing(f) { t.Error() }
+}
@@ -0,0 +1,87 @@
+package dc
+
+import "errors"
+import "os"
+import "path/filepath"
+import "strings"
+
+/* Rules for which entries underneath a share should not be shared. Globs are
+ * matched against both the name of an entry and its path relative to the root
+ * of the share. Sizes of 0 mean that there's no bound, and extensions are
+ * compared case-insensitively without the leading dot. */
+type ShareFilter struct {
+  Symlinks   SymlinkPolicy
+  Globs      []string
+  HideDotfiles bool
+  MinSize    ByteSize
+  MaxSize    ByteSize
+  AllowExts  []string
+  DenyExts   []string
+}
+
+/* Returns whether the entry at the relative path should be left out */
+func (f *ShareFilter) excludes(rel string, info os.FileInfo) bool {
+  name := info.Name()
+  if f.HideDotfiles && strings.HasPrefix(name, ".") {
+    return true
+  }
+  for _, glob := range f.Globs {
+    if m, _ := filepath.Match(glob, name); m { return true }
+    if m, _ := filepath.Match(glob, filepath.ToSlash(rel)); m { return true }
+  }
+  if info.IsDir() {
+    return false
+  }
+
+  size := ByteSize(info.Size())
+  if f.MinSize > 0 && size < f.MinSize { return true }
+  if f.MaxSize > 0 && size > f.MaxSize { return true }
+  ext := normalizeExt(filepath.Ext(name))
+  if len(f.AllowExts) > 0 && !containsExt(f.AllowExts, ext) {
+    return true
+  }
+  return containsExt(f.DenyExts, ext)
+}
+
+/* How symlinks found underneath a share are treated. When the global policy
+ * and a share's policy differ, the more restrictive one wins. */
+type SymlinkPolicy int
+
+const (
+  FollowSymlinks SymlinkPolicy = iota
+  FollowSymlinksWithinShare
+  SkipSymlinks
+)
+
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+  switch s {
+  case "follow": return FollowSymlinks, nil
+  case "within": return FollowSymlinksWithinShare, nil
+  case "skip":   return SkipSymlinks, nil
+  }
+  return FollowSymlinks, errors.New("unknown symlink policy: " + s)
+}
+
+func (p SymlinkPolicy) String() string {
+  switch p {
+  case FollowSymlinksWithinShare: return "within"
+  case SkipSymlinks:              return "skip"
+  }
+  return "follow"
+}
+
+func normalizeExt(ext string) string {
+  return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+func containsExt(exts []string, ext string) bool {
+  for _, e := range exts {
+    if normalizeExt(e) == ext { return true }
+  }
+  return false
+}
+
+/* Whether the global rules or the share's own rules exclude an entry */
+func (s *Shares) excluded(sh *Share, rel string, info os.FileInfo) bool {
+  return s.filter.excludes(rel, info) || sh.Filter.excludes(rel, info)
+}
@@ -0,0 +1,160 @@
+package dc
+
+import "encoding/gob"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+import "github.com/alexcrichton/fargo/dc/tth"
+
+/* The hash database remembers the TTH of every file that we've hashed, keyed
+ * by the real path of the file. An entry is only trusted if the size,
+ * modification time and inode (where available) of the file all still match
+ * what was recorded at the time of hashing.
+ *
+ * The leaves of each file's hash tree are kept next to the database, one file
+ * per TTH, because they're only needed when a peer asks for them. */
+type hashDB struct {
+  entries map[string]*hashEntry
+  dirty   bool
+  dir     string
+}
+
+type hashEntry struct {
+  Size   ByteSize
+  Mtime  int64
+  Inode  uint64
+  TTH    string
+  Leaves bool /* whether leaves were kept when this was hashed */
+}
+
+/* on-disk representation, versioned so the format can change later */
+type hashDBFile struct {
+  Version int
+  Entries map[string]*hashEntry
+}
+
+const hashDBVersion = 1
+const hashDBName = "hashes.db"
+const leavesDir = "leaves"
+
+func newHashDB() *hashDB {
+  return &hashDB{entries: make(map[string]*hashEntry)}
+}
+
+/* Loads the database from the cache directory. A missing database is not an
+ * error, it just means that everything will need to be hashed */
+func (h *hashDB) load(dir string) error {
+  h.dir = dir
+  file, err := os.Open(filepath.Join(dir, hashDBName))
+  if os.IsNotExist(err) { return nil }
+  if err != nil { return err }
+  defer file.Close()
+
+  var contents hashDBFile
+  err = gob.NewDecoder(file).Decode(&contents)
+  if err != nil { return err }
+  if contents.Version != hashDBVersion || contents.Entries == nil {
+    return nil
+  }
+  h.entries = contents.Entries
+  h.dirty = false
+  return nil
+}
+
+/* Writes the database out to the cache directory. The contents are written to
+ * a temporary file which is synced and then renamed over the old database, so
+ * a crash mid-write leaves the previous version intact. */
+func (h *hashDB) save(dir string) error {
+  if !h.dirty { return nil }
+  err := os.MkdirAll(dir, os.FileMode(0755))
+  if err != nil { return err }
+  dst := filepath.Join(dir, hashDBName)
+  file, err := os.Create(dst + ".tmp")
+  if err != nil { return err }
+
+  contents := hashDBFile{Version: hashDBVersion, Entries: h.entries}
+  err = gob.NewEncoder(file).Encode(&contents)
+  if err == nil {
+    err = file.Sync()
+  }
+  if err2 := file.Close(); err == nil {
+    err = err2
+  }
+  if err == nil {
+    err = os.Rename(file.Name(), dst)
+  }
+  if err != nil {
+    os.Remove(file.Name())
+    return err
+  }
+  h.dirty = false
+  return nil
+}
+
+/* Returns the TTH recorded for the file if it hasn't changed since it was
+ * hashed, or the empty string if it needs to be hashed again */
+func (h *hashDB) lookup(realpath string, info os.FileInfo) string {
+  e := h.entries[realpath]
+  if e == nil { return "" }
+  if e.Size != ByteSize(info.Size()) ||
+     e.Mtime != info.ModTime().UnixNano() ||
+     e.Inode != inode(info) {
+    return ""
+  }
+  /* Files hashed before leaves were kept need to be hashed again, unless
+   * their only leaf is the root */
+  if !e.Leaves && e.Size > tth.BlockSize {
+    return ""
+  }
+  return e.TTH
+}
+
+func (h *hashDB) update(f *File) {
+  h.entries[f.realpath] = &hashEntry{Size:   f.Size,
+                                     Mtime:  f.mtime.UnixNano(),
+                                     Inode:  f.inode,
+                                     TTH:    f.TTH,
+                                     Leaves: true}
+  h.dirty = true
+}
+
+/* Stores the leaves of a file's hash tree. A single leaf is the root itself,
+ * so there's no need to keep it around. */
+func (h *hashDB) storeLeaves(root string, leaves []byte) error {
+  if h.dir == "" || len(leaves) <= tth.Size { return nil }
+  dir := filepath.Join(h.dir, leavesDir)
+  err := os.MkdirAll(dir, os.FileMode(0755))
+  if err != nil { return err }
+  dst := filepath.Join(dir, root)
+  err = ioutil.WriteFile(dst + ".tmp", leaves, os.FileMode(0644))
+  if err == nil {
+    err = os.Rename(dst + ".tmp", dst)
+  }
+  return err
+}
+
+/* Forgets the leaves for a TTH which is no longer shared */
+func (h *hashDB) dropLeaves(root string) {
+  if h.dir == "" || root == "" || root == "fail" { return }
+  os.Remove(filepath.Join(h.dir, leavesDir, root))
+}
+
+/* Reads the leaves stored for a TTH out of a cache directory. When none were
+ * stored the root is returned, it's a tree of one leaf. */
+func loadLeaves(dir, root string) ([]byte, error) {
+  sum, err := tth.Decode(root)
+  if err != nil { return nil, err }
+  leaves, err := ioutil.ReadFile(filepath.Join(dir, leavesDir, root))
+  if os.IsNotExist(err) {
+    return sum, nil
+  }
+  return leaves, err
+}
+
+func (h *hashDB) remove(realpath string) {
+  if h.entries[realpath] != nil {
+    delete(h.entries, realpath)
+    h.dirty = true
+  }
+}
@@ -0,0 +1,119 @@
+package dc
+
+import "bytes"
+import "testing"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "time"
+
+import "github.com/alexcrichton/fargo/dc/tth"
+
+func Test_HashDBRoundTrip(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  err := ioutil.WriteFile(wd + "/a", []byte("a"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  info, err := os.Stat(wd + "/a")
+  if err != nil { t.Fatal(err) }
+
+  db := newHashDB()
+  db.update(&File{Size: 1, TTH: "tth", realpath: wd + "/a",
+                  mtime: info.ModTime(), inode: inode(info)})
+  err = db.save(wd)
+  if err != nil { t.Fatal(err) }
+  if _, err := os.Stat(wd + "/" + hashDBName + ".tmp"); err == nil {
+    t.Error("temporary database left behind")
+  }
+
+  db = newHashDB()
+  err = db.load(wd)
+  if err != nil { t.Fatal(err) }
+  if db.lookup(wd + "/a", info) != "tth" { t.Error(db.lookup(wd + "/a", info)) }
+  if db.lookup(wd + "/b", info) != "" { t.Error() }
+
+  /* changing the file invalidates the entry */
+  later := info.ModTime().Add(time.Second)
+  err = os.Chtimes(wd + "/a", later, later)
+  if err != nil { t.Fatal(err) }
+  info, err = os.Stat(wd + "/a")
+  if err != nil { t.Fatal(err) }
+  if db.lookup(wd + "/a", info) != "" { t.Error() }
+}
+
+func Test_HashDBMissing(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  db := newHashDB()
+  err := db.load(wd)
+  if err != nil { t.Fatal(err) }
+  if len(db.entries) != 0 { t.Error(len(db.entries)) }
+}
+
+func Test_HashDBSkipsRehashing(t *testing.T) {
+  shares, wd := setup(t)
+  shares.add("name", "foo")
+  f := shares.queryWait("name/a")
+  if f == nil || f.TTH != "CZQUWH3IYXBF5L3BGYUGZHASSMXU647IP2IKE4Y" {
+    t.Fatal(f)
+  }
+  shares.halt()
+  defer os.RemoveAll(wd)
+
+  /* Doctor the database so we can tell whether the file was rehashed */
+  db := newHashDB()
+  err := db.load(filepath.Join(wd, "cache"))
+  if err != nil { t.Fatal(err) }
+  db.entries[filepath.Join(wd, "foo/a")].TTH = "restored"
+  db.dirty = true
+  err = db.save(filepath.Join(wd, "cache"))
+  if err != nil { t.Fatal(err) }
+
+  c := NewClient()
+  c.CacheDir = filepath.Join(wd, "cache")
+  _shares := NewShares()
+  shares = &_shares
+  go shares.hash(c)
+  defer shares.halt()
+  shares.add("name", "foo")
+  f = shares.queryWait("name/a")
+  if f == nil || f.TTH != "restored" { t.Fatal(f) }
+  f = shares.queryWait("name/bar/a")
+  if f == nil || f.TTH != "CZQUWH3IYXBF5L3BGYUGZHASSMXU647IP2IKE4Y" {
+    t.Fatal(f)
+  }
+}
+
+func Test_HashDBLeaves(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  db := newHashDB()
+  err := db.load(wd)
+  if err != nil { t.Fatal(err) }
+
+  data := []byte(strings.Repeat("a", 3000))
+  root, leaves, err := tth.HashLeaves(bytes.NewReader(data), 3000, 8, nil)
+  if err != nil { t.Fatal(err) }
+  err = db.storeLeaves(root, leaves)
+  if err != nil { t.Fatal(err) }
+  got, err := loadLeaves(wd, root)
+  if err != nil || !bytes.Equal(got, leaves) { t.Error(err) }
+
+  /* without stored leaves the root is the only one */
+  db.dropLeaves(root)
+  got, err = loadLeaves(wd, root)
+  if err != nil || tth.Encode(got) != root { t.Error(err) }
+  if _, err = loadLeaves(wd, "../" + hashDBName); err == nil { t.Error() }
+
+  /* large files hashed before leaves were kept get hashed again */
+  err = ioutil.WriteFile(wd + "/a", data, os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  info, err := os.Stat(wd + "/a")
+  if err != nil { t.Fatal(err) }
+  db.update(&File{Size: 3000, TTH: root, realpath: wd + "/a",
+                  mtime: info.ModTime(), inode: inode(info)})
+  if db.lookup(wd + "/a", info) != root { t.Error() }
+  db.entries[wd + "/a"].Leaves = false
+  if db.lookup(wd + "/a", info) != "" { t.Error() }
+}
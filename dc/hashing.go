@@ -0,0 +1,153 @@
+package dc
+
+import "container/heap"
+import "errors"
+import "io"
+import "sync"
+import "time"
+
+/* Controls over how shared files get hashed. A rate of 0 means that reading
+ * files while hashing isn't limited. Leaves is the most leaves of each file's
+ * hash tree which are kept for peers to verify pieces with, 0 meaning
+ * DefaultLeaves. Changing it only affects files hashed afterwards. */
+type HashSettings struct {
+  Workers int
+  Rate    ByteSize /* per second, across all workers */
+  Order   HashOrder
+  Leaves  int
+}
+
+var DefaultLeaves = 512
+
+/* The order in which files waiting to be hashed are handed out. Hashing small
+ * files first gets as much of the list published as soon as possible. */
+type HashOrder int
+
+const (
+  HashSmallestFirst HashOrder = iota
+  HashLargestFirst
+  HashInOrder
+)
+
+func ParseHashOrder(s string) (HashOrder, error) {
+  switch s {
+  case "smallest": return HashSmallestFirst, nil
+  case "largest":  return HashLargestFirst, nil
+  case "fifo":     return HashInOrder, nil
+  }
+  return HashSmallestFirst, errors.New("unknown hash order: " + s)
+}
+
+func (o HashOrder) String() string {
+  switch o {
+  case HashLargestFirst: return "largest"
+  case HashInOrder:      return "fifo"
+  }
+  return "smallest"
+}
+
+/* Files waiting to be hashed, kept as a heap ordered by the hash order */
+type hashQueue struct {
+  order   HashOrder
+  entries []queued
+  seq     uint64
+}
+
+type queued struct {
+  file *File
+  seq  uint64
+}
+
+func (q *hashQueue) Len() int { return len(q.entries) }
+
+func (q *hashQueue) Less(i, j int) bool {
+  a, b := &q.entries[i], &q.entries[j]
+  switch {
+  case q.order == HashSmallestFirst && a.file.Size != b.file.Size:
+    return a.file.Size < b.file.Size
+  case q.order == HashLargestFirst && a.file.Size != b.file.Size:
+    return a.file.Size > b.file.Size
+  }
+  return a.seq < b.seq
+}
+
+func (q *hashQueue) Swap(i, j int) {
+  q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+}
+
+func (q *hashQueue) Push(x interface{}) {
+  q.entries = append(q.entries, x.(queued))
+}
+
+func (q *hashQueue) Pop() interface{} {
+  back := len(q.entries) - 1
+  e := q.entries[back]
+  q.entries[back] = queued{}
+  q.entries = q.entries[0:back]
+  return e
+}
+
+func (q *hashQueue) add(f *File) {
+  q.seq++
+  heap.Push(q, queued{file: f, seq: q.seq})
+}
+
+func (q *hashQueue) peek() *File {
+  return q.entries[0].file
+}
+
+func (q *hashQueue) next() *File {
+  return heap.Pop(q).(queued).file
+}
+
+func (q *hashQueue) setOrder(order HashOrder) {
+  q.order = order
+  heap.Init(q)
+}
+
+/* Paces reads so that, between everyone using the limiter, no more than the
+ * rate is read each second */
+type rateLimiter struct {
+  sync.Mutex
+  rate ByteSize
+  next time.Time
+}
+
+func (l *rateLimiter) set(rate ByteSize) {
+  l.Lock()
+  l.rate = rate
+  l.Unlock()
+}
+
+/* Accounts for n bytes having been read, sleeping until the rate allows it */
+func (l *rateLimiter) wait(n int) {
+  l.Lock()
+  if l.rate == 0 {
+    l.Unlock()
+    return
+  }
+  now := time.Now()
+  if l.next.Before(now) {
+    l.next = now
+  }
+  l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) /
+                                    int64(l.rate)))
+  delay := l.next.Sub(now)
+  l.Unlock()
+  time.Sleep(delay)
+}
+
+/* Reads in small pieces so that pacing is smooth */
+type throttled struct {
+  r     io.Reader
+  limit *rateLimiter
+}
+
+func (t *throttled) Read(p []byte) (int, error) {
+  if len(p) > 64 * 1024 {
+    p = p[:64 * 1024]
+  }
+  n, err := t.r.Read(p)
+  t.limit.wait(n)
+  return n, err
+}
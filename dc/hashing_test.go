@@ -0,0 +1,113 @@
+package dc
+
+import "bytes"
+import "io"
+import "io/ioutil"
+import "testing"
+import "time"
+
+func Test_HashOrder(t *testing.T) {
+  sizes := []ByteSize{5, 1, 3, 1, 4}
+  files := make([]*File, len(sizes))
+  for i, size := range sizes {
+    files[i] = &File{Size: size}
+  }
+  drain := func(q *hashQueue) []*File {
+    out := make([]*File, 0)
+    for q.Len() > 0 {
+      if q.peek() != q.entries[0].file { t.Error() }
+      out = append(out, q.next())
+    }
+    return out
+  }
+  fill := func(order HashOrder) *hashQueue {
+    q := &hashQueue{}
+    q.setOrder(order)
+    for _, f := range files {
+      q.add(f)
+    }
+    return q
+  }
+
+  out := drain(fill(HashSmallestFirst))
+  /* ties are broken by when files were queued */
+  expected := []*File{files[1], files[3], files[2], files[4], files[0]}
+  for i := range expected {
+    if out[i] != expected[i] { t.Errorf("smallest %d: %v", i, out[i].Size) }
+  }
+  out = drain(fill(HashLargestFirst))
+  expected = []*File{files[0], files[4], files[2], files[1], files[3]}
+  for i := range expected {
+    if out[i] != expected[i] { t.Errorf("largest %d: %v", i, out[i].Size) }
+  }
+
+  /* the order can change with things already queued */
+  q := fill(HashLargestFirst)
+  q.setOrder(HashInOrder)
+  out = drain(q)
+  for i := range files {
+    if out[i] != files[i] { t.Errorf("fifo %d: %v", i, out[i].Size) }
+  }
+}
+
+func Test_ParseHashOrder(t *testing.T) {
+  for _, s := range []string{"smallest", "largest", "fifo"} {
+    o, err := ParseHashOrder(s)
+    if err != nil { t.Error(err) }
+    if o.String() != s { t.Error(o.String()) }
+  }
+  if _, err := ParseHashOrder("random"); err == nil { t.Error() }
+}
+
+func Test_RateLimit(t *testing.T) {
+  limit := &rateLimiter{}
+  data := make([]byte, 200 * 1024)
+
+  /* unlimited by default */
+  start := time.Now()
+  in := &throttled{r: bytes.NewReader(data), limit: limit}
+  n, err := io.Copy(ioutil.Discard, in)
+  if err != nil || n != int64(len(data)) { t.Fatal(n, err) }
+  if time.Since(start) > 100 * time.Millisecond { t.Error(time.Since(start)) }
+
+  limit.set(MB)
+  start = time.Now()
+  in = &throttled{r: bytes.NewReader(data), limit: limit}
+  n, err = io.Copy(ioutil.Discard, in)
+  if err != nil || n != int64(len(data)) { t.Fatal(n, err) }
+  if time.Since(start) < 150 * time.Millisecond { t.Error(time.Since(start)) }
+}
+
+func Test_ChangeHashers(t *testing.T) {
+  WatchDelay = 10 * time.Millisecond
+  shares, wd := setup(t)
+  defer teardown(shares, wd)
+
+  shares.settings <- HashSettings{Workers: 4, Order: HashLargestFirst}
+  shares.add("name", "foo")
+  if f := shares.queryWait("name/bar/a"); f == nil || f.TTH == "" {
+    t.Fatal(f)
+  }
+  shares.cmds <- getstats
+  stats := <-shares.stats
+  if stats.Hash.Workers != 4 { t.Error(stats.Hash.Workers) }
+  if stats.Hash.Order != HashLargestFirst { t.Error(stats.Hash.Order) }
+
+  /* hashing keeps working with fewer workers */
+  shares.settings <- HashSettings{Workers: 1, Rate: 10 * MB}
+  err := ioutil.WriteFile("foo/new", []byte("dd"), 0644)
+  if err != nil { t.Fatal(err) }
+  shares.cmds <- rescan
+  f := shares.queryWait("name/new")
+  if f == nil || f.TTH != "FZH4TYZRKFCNCEVOUREOOKQ2U32LUPMSCGYXAMY" {
+    t.Error(f)
+  }
+  shares.cmds <- getstats
+  stats = <-shares.stats
+  if stats.Hash.Workers != 1 || stats.Hash.Rate != 10 * MB {
+    t.Error(stats.Hash)
+  }
+
+  c := NewClient()
+  if c.SetHashSettings(HashSettings{Workers: 0}) == nil { t.Error() }
+}
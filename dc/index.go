@@ -0,0 +1,82 @@
+package dc
+
+import "path"
+
+/* Shared files indexed by TTH. Identical files have the same TTH, so removing
+ * one of them has to leave the others findable. */
+type tthIndex map[string][]*File
+
+func (t tthIndex) add(f *File) {
+  if f.TTH == "" || f.TTH == "fail" { return }
+  for _, other := range t[f.TTH] {
+    if other == f { return }
+  }
+  t[f.TTH] = append(t[f.TTH], f)
+}
+
+func (t tthIndex) remove(f *File) {
+  files := t[f.TTH]
+  for i, other := range files {
+    if other != f { continue }
+    if len(files) == 1 {
+      delete(t, f.TTH)
+    } else {
+      files[i] = files[len(files) - 1]
+      files[len(files) - 1] = nil
+      t[f.TTH] = files[:len(files) - 1]
+    }
+    return
+  }
+}
+
+func (t tthIndex) find(tth string) *File {
+  if files := t[tth]; len(files) > 0 {
+    return files[0]
+  }
+  return nil
+}
+
+/* Creates a new file in a directory of the share list. The path of each file
+ * is kept around as the key it's indexed by. */
+func (s *Shares) newFile(d *Directory, name, realpath string) *File {
+  file := &File{Name: name, realpath: realpath, path: path.Join(d.path, name)}
+  d.addFile(file)
+  s.paths[file.path] = file
+  return file
+}
+
+func (s *Shares) newDir(d *Directory, name, realpath string) *Directory {
+  dir := d.addDir(NewDirectory(name, realpath))
+  dir.path = path.Join(d.path, name)
+  return dir
+}
+
+/* Removes a file from the indices and the hash database. The caller is
+ * responsible for removing it from its directory. */
+func (s *Shares) drop(f *File) {
+  if s.paths[f.path] == f {
+    delete(s.paths, f.path)
+  }
+  if s.keys[f.key] == f {
+    delete(s.keys, f.key)
+  }
+  s.unindex(f)
+  s.db.remove(f.realpath)
+}
+
+/* Changes the TTH of a file, keeping the index up to date */
+func (s *Shares) setTTH(f *File, tth string) {
+  if f.TTH == tth { return }
+  s.unindex(f)
+  f.TTH = tth
+  s.tthMap.add(f)
+}
+
+/* Removes a file from the TTH index, along with the leaves of its tree if no
+ * other file has the same contents */
+func (s *Shares) unindex(f *File) {
+  s.tthMap.remove(f)
+  if f.TTH != "" && s.tthMap.find(f.TTH) == nil {
+    s.db.dropLeaves(f.TTH)
+  }
+}
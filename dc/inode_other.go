@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package dc
+
+import "os"
+
+/* No inode numbers are available through os.FileInfo on these platforms */
+func inode(info os.FileInfo) uint64 {
+  return 0
+}
+
+func keyOf(path string, info os.FileInfo) fileKey {
+  return fileKey{path: resolvedPath(path)}
+}
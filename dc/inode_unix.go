@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package dc
+
+import "os"
+import "syscall"
+
+func inode(info os.FileInfo) uint64 {
+  if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+    return uint64(stat.Ino)
+  }
+  return 0
+}
+
+func keyOf(path string, info os.FileInfo) fileKey {
+  if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+    return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+  }
+  return fileKey{path: resolvedPath(path)}
+}
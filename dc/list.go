@@ -1,11 +1,13 @@
 package dc
 
+import "bytes"
 import "encoding/xml"
 import "errors"
 import "fmt"
 import "io"
 import "io/ioutil"
 import "path"
+import "strconv"
 import "strings"
 import "time"
 
@@ -23,12 +25,22 @@ type FileListing struct {
 
 type Directory struct {
   Name string `xml:",attr,omitempty"`
+  /* Set in partial lists when the contents of the directory were left out */
+  Incomplete int `xml:",attr,omitempty"`
 
   Dirs  []Directory `xml:"Directory"`
   Files []*File     `xml:"File"`
 
   realpath string
+  path     string
   version  uint64
+
+  /* Positions of children by name. These are only built for directories with
+   * enough entries to make scanning slower. They're built up front and kept
+   * up to date as children come and go, so looking something up never writes
+   * to a listing which others may be reading at the same time. */
+  dirIdx   map[string]int
+  fileIdx  map[string]int
 }
 
 type File struct {
@@ -37,13 +49,19 @@ type File struct {
   TTH  string   `xml:",attr"`
 
   mtime    time.Time
+  inode    uint64
+  key      fileKey /* what it really is, however it was reached */
   version  uint64
   realpath string
+  path     string
   hashProgress uint64
 }
 
 type VisitFunc func(*File, string) error
 
+/* Directories with fewer entries than this are searched linearly */
+const indexThreshold = 16
+
 var FileNotFound = errors.New("File not found")
 var DirectoryNotFound = errors.New("Directory not found")
 
@@ -76,14 +94,24 @@ func (d *Directory) Swap(i, j int) {
   if i < dirs {
     if j >= dirs { return }
     d.Dirs[i], d.Dirs[j] = d.Dirs[j], d.Dirs[i]
+    if d.dirIdx != nil {
+      d.dirIdx[d.Dirs[i].Name], d.dirIdx[d.Dirs[j].Name] = i, j
+    }
   } else {
     if j < dirs { return }
-    d.Files[i - dirs], d.Files[j - dirs] = d.Files[j - dirs], d.Files[i - dirs]
+    i, j = i - dirs, j - dirs
+    d.Files[i], d.Files[j] = d.Files[j], d.Files[i]
+    if d.fileIdx != nil {
+      d.fileIdx[d.Files[i].Name], d.fileIdx[d.Files[j].Name] = i, j
+    }
   }
 }
 
 func ParseFileList(in io.Reader, out *FileListing) (err error) {
-  defer func() { out.Name = out.Base }()
+  defer func() {
+    out.Name = out.Base
+    out.indexAll()
+  }()
   data, err := ioutil.ReadAll(in)
   if err != nil { return }
 
@@ -108,6 +136,95 @@ func EncodeFileList(in *FileListing, out io.Writer) (err error) {
   return encoder.Encode(in)
 }
 
+/* Encodes the listing of a single directory, with its path as the base. Unless
+ * the listing is recursive, subdirectories are only named and are marked as
+ * incomplete if they have any contents. Returns nil if the directory doesn't
+ * exist. */
+func (f *FileListing) partial(dir string, recursive bool) []byte {
+  dir = strings.Trim(dir, "/")
+  d, err := f.FindDir(dir)
+  if err != nil { return nil }
+
+  base := "/"
+  if dir != "" {
+    base = "/" + dir + "/"
+  }
+  out := FileListing{Version: f.Version, Generator: f.Generator, Base: base,
+                     Directory: *d}
+  out.Name = ""
+  if !recursive {
+    out.Dirs = make([]Directory, len(d.Dirs))
+    for i, sub := range d.Dirs {
+      out.Dirs[i] = Directory{Name: sub.Name}
+      if sub.Len() > 0 {
+        out.Dirs[i].Incomplete = 1
+      }
+    }
+  }
+
+  var buf bytes.Buffer
+  if EncodeFileList(&out, &buf) != nil { return nil }
+  return buf.Bytes()
+}
+
+/* Merges a partial listing of the directory at base into this listing, and
+ * returns the result. Directories on the way to base are copied rather than
+ * modified, so anything previously found in this listing is left alone.
+ * Subdirectories which were already explored aren't forgotten just because
+ * the partial listing left out their contents. */
+func (f *FileListing) merge(base string, part *FileListing) *FileListing {
+  out := *f
+  cur := &out.Directory
+  for _, name := range strings.Split(strings.Trim(base, "/"), "/") {
+    if name == "" { continue }
+    dirs := make([]Directory, len(cur.Dirs), len(cur.Dirs) + 1)
+    copy(dirs, cur.Dirs)
+    cur.Dirs = dirs
+    cur.index()
+    child := cur.childDir(name)
+    if child == nil {
+      child = cur.addDir(Directory{Name: name, Incomplete: 1})
+    }
+    cur = child
+  }
+
+  old := *cur
+  cur.Files = part.Files
+  cur.Dirs = part.Dirs
+  cur.Incomplete = 0
+  for i, dir := range cur.Dirs {
+    if dir.Incomplete == 0 { continue }
+    if prev := old.childDir(dir.Name); prev != nil && prev.Incomplete == 0 {
+      cur.Dirs[i] = *prev
+    }
+  }
+  cur.index()
+  return &out
+}
+
+/* Whether the contents of a directory have yet to be fetched with a partial
+ * listing, either because it's marked as incomplete or because it's missing
+ * from a directory which is */
+func (f *FileListing) unexplored(dir string) bool {
+  cur := &f.Directory
+  for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+    if name == "" { continue }
+    child := cur.childDir(name)
+    if child == nil { return cur.Incomplete != 0 }
+    cur = child
+  }
+  return cur.Incomplete != 0
+}
+
+/* Whether any part of this directory's subtree has yet to be fetched */
+func (d *Directory) incomplete() bool {
+  if d.Incomplete != 0 { return true }
+  for i := range d.Dirs {
+    if d.Dirs[i].incomplete() { return true }
+  }
+  return false
+}
+
 func (f *FileListing) FindDir(dir string) (*Directory, error) {
   if dir == "" || dir == "/" { return &f.Directory, nil }
 
@@ -117,15 +234,8 @@ func (f *FileListing) FindDir(dir string) (*Directory, error) {
   }
   cur := &f.Directory
   for _, subdir := range parts {
-    found := false
-    for i, child := range cur.Dirs {
-      if child.Name == subdir {
-        found = true
-        cur = &cur.Dirs[i]
-        break
-      }
-    }
-    if !found {
+    cur = cur.childDir(subdir)
+    if cur == nil {
       return nil, errors.New(dir + " is not a directory")
     }
   }
@@ -137,9 +247,7 @@ func (f *FileListing) FindFile(pathname string) (file *File, err error) {
   if len(dirname) == 0 { return nil, FileNotFound }
   dir, err := f.FindDir(dirname[0:len(dirname)-1])
   if err != nil { return }
-  for i, f := range dir.Files {
-    if f.Name == base { return dir.Files[i], nil }
-  }
+  if file = dir.childFile(base); file != nil { return file, nil }
   return nil, FileNotFound
 }
 
@@ -155,51 +263,124 @@ func (d *Directory) visit(pathname string, cb VisitFunc) error {
   return nil
 }
 
-func (d *Directory) childFile(name string) *File {
+/* Builds the name lookups from scratch. Fresh maps are made rather than
+ * clearing the old ones, which copies of this directory may still share. */
+func (d *Directory) index() {
+  d.dirIdx, d.fileIdx = nil, nil
+  if len(d.Dirs) >= indexThreshold {
+    d.dirIdx = make(map[string]int, len(d.Dirs))
+    for i := range d.Dirs {
+      d.dirIdx[d.Dirs[i].Name] = i
+    }
+  }
+  if len(d.Files) >= indexThreshold {
+    d.fileIdx = make(map[string]int, len(d.Files))
+    for i, file := range d.Files {
+      d.fileIdx[file.Name] = i
+    }
+  }
+}
+
+/* Builds the name lookups for a whole tree, as when it's been parsed */
+func (d *Directory) indexAll() {
+  d.index()
+  for i := range d.Dirs {
+    d.Dirs[i].indexAll()
+  }
+}
+
+func (d *Directory) dirPos(name string) int {
+  if d.dirIdx != nil {
+    if i, ok := d.dirIdx[name]; ok { return i }
+    return -1
+  }
+  for i := range d.Dirs {
+    if d.Dirs[i].Name == name { return i }
+  }
+  return -1
+}
+
+func (d *Directory) filePos(name string) int {
+  if d.fileIdx != nil {
+    if i, ok := d.fileIdx[name]; ok { return i }
+    return -1
+  }
   for i, file := range d.Files {
-    if file.Name == name { return d.Files[i] }
+    if file.Name == name { return i }
   }
+  return -1
+}
+
+func (d *Directory) childFile(name string) *File {
+  if i := d.filePos(name); i != -1 { return d.Files[i] }
   return nil
 }
 
 func (d *Directory) childDir(name string) *Directory {
-  for i, dir := range d.Dirs {
-    if dir.Name == name { return &d.Dirs[i] }
-  }
+  if i := d.dirPos(name); i != -1 { return &d.Dirs[i] }
   return nil
 }
 
+/* Adds a subdirectory, returning where it now lives. Pointers to the other
+ * subdirectories may no longer be valid afterwards. */
+func (d *Directory) addDir(dir Directory) *Directory {
+  d.Dirs = append(d.Dirs, dir)
+  if d.dirIdx != nil {
+    d.dirIdx[dir.Name] = len(d.Dirs) - 1
+  } else if len(d.Dirs) >= indexThreshold {
+    d.index()
+  }
+  return &d.Dirs[len(d.Dirs) - 1]
+}
+
+func (d *Directory) addFile(file *File) {
+  d.Files = append(d.Files, file)
+  if d.fileIdx != nil {
+    d.fileIdx[file.Name] = len(d.Files) - 1
+  } else if len(d.Files) >= indexThreshold {
+    d.index()
+  }
+}
+
 func (d *Directory) removeDir(i int) {
   back := len(d.Dirs) - 1
+  if d.dirIdx != nil {
+    delete(d.dirIdx, d.Dirs[i].Name)
+  }
   if i < back {
     d.Dirs[i] = d.Dirs[back]
+    if d.dirIdx != nil {
+      d.dirIdx[d.Dirs[i].Name] = i
+    }
   }
+  d.Dirs[back] = Directory{} /* don't keep the subtree alive */
   d.Dirs = d.Dirs[0:back]
 }
 
 func (d *Directory) removeDirName(name string) {
-  for i, dir := range d.Dirs {
-    if dir.Name == name {
-      d.removeDir(i)
-      break
-    }
+  if i := d.dirPos(name); i != -1 {
+    d.removeDir(i)
   }
 }
 
 func (d *Directory) removeFileName(name string) {
-  for i, file := range d.Files {
-    if file.Name == name {
-      d.removeFile(i)
-      break
-    }
+  if i := d.filePos(name); i != -1 {
+    d.removeFile(i)
   }
 }
 
 func (d *Directory) removeFile(i int) {
   back := len(d.Files) - 1
+  if d.fileIdx != nil {
+    delete(d.fileIdx, d.Files[i].Name)
+  }
   if i < back {
     d.Files[i] = d.Files[back]
+    if d.fileIdx != nil {
+      d.fileIdx[d.Files[i].Name] = i
+    }
   }
+  d.Files[back] = nil
   d.Files = d.Files[0:back]
 }
 
@@ -239,3 +420,25 @@ func (b ByteSize) String() string {
   }
   return fmt.Sprintf("%.2fB", bf)
 }
+
+/* Parses sizes like "100", "1.5GB" or "10m" into a number of bytes */
+func ParseByteSize(s string) (ByteSize, error) {
+  s = strings.ToUpper(strings.TrimSpace(s))
+  s = strings.TrimSuffix(s, "B")
+  mult := ByteSize(1)
+  if len(s) > 0 {
+    switch s[len(s)-1] {
+    case 'K': mult = KB
+    case 'M': mult = MB
+    case 'G': mult = GB
+    case 'T': mult = TB
+    }
+    if mult != 1 {
+      s = s[:len(s)-1]
+    }
+  }
+  f, err := strconv.ParseFloat(s, 64)
+  if err != nil { return 0, err }
+  if f < 0 { return 0, errors.New("negative size: " + s) }
+  return ByteSize(f * float64(mult)), nil
+}
@@ -1,7 +1,9 @@
 package dc
 
+import "bytes"
 import "io"
 import "sort"
+import "strconv"
 import "strings"
 import "testing"
 
@@ -181,3 +183,172 @@ func Test_ByteSizeStringification(t *testing.T) {
   s = ByteSize(100 << 30).String()
   if s != "100.00GB" { t.Error(s) }
 }
+
+func Test_ParseByteSize(t *testing.T) {
+  s, err := ParseByteSize("100")
+  if err != nil || s != 100 { t.Error(s, err) }
+  s, err = ParseByteSize("10KB")
+  if err != nil || s != 10 * KB { t.Error(s, err) }
+  s, err = ParseByteSize("1.5g")
+  if err != nil || s != GB + GB / 2 { t.Error(s, err) }
+  s, err = ParseByteSize("2M")
+  if err != nil || s != 2 * MB { t.Error(s, err) }
+  _, err = ParseByteSize("lots")
+  if err == nil { t.Error() }
+  _, err = ParseByteSize("-1")
+  if err == nil { t.Error() }
+}
+
+func Test_PartialListing(t *testing.T) {
+  list := `
+    <FileListing Base="/" Version="1" Generator="fargo">
+      <Directory Name="shared">
+        <File Name="a" Size="1" TTH="ttha"/>
+        <Directory Name="c">
+          <File Name="d" Size="3" TTH="tthc"/>
+        </Directory>
+        <Directory Name="empty"></Directory>
+      </Directory>
+    </FileListing>
+  `
+  var full FileListing
+  err := ParseFileList(strings.NewReader(list), &full)
+  if err != nil { t.Fatal(err) }
+
+  if full.partial("/missing/", false) != nil { t.Error() }
+
+  /* only the immediate children are listed */
+  var listing FileListing
+  err = ParseFileList(bytes.NewReader(full.partial("/shared/", false)),
+                      &listing)
+  if err != nil { t.Fatal(err) }
+  if listing.Base != "/shared/" { t.Error(listing.Base) }
+  if len(listing.Files) != 1 || listing.Files[0].TTH != "ttha" {
+    t.Error(listing.Files)
+  }
+  if len(listing.Dirs) != 2 { t.Fatal(len(listing.Dirs)) }
+  if listing.Dirs[0].Name != "c" { t.Error(listing.Dirs[0].Name) }
+  if listing.Dirs[0].Incomplete != 1 { t.Error() }
+  if len(listing.Dirs[0].Files) != 0 { t.Error() }
+  if listing.Dirs[1].Incomplete != 0 { t.Error() }
+
+  /* the root of the listing */
+  listing = FileListing{}
+  err = ParseFileList(bytes.NewReader(full.partial("/", false)), &listing)
+  if err != nil { t.Fatal(err) }
+  if listing.Base != "/" { t.Error(listing.Base) }
+  if len(listing.Dirs) != 1 || listing.Dirs[0].Incomplete != 1 {
+    t.Error(listing.Dirs)
+  }
+
+  /* recursive listings include everything */
+  listing = FileListing{}
+  err = ParseFileList(bytes.NewReader(full.partial("shared", true)), &listing)
+  if err != nil { t.Fatal(err) }
+  if len(listing.Dirs) != 2 { t.Fatal(len(listing.Dirs)) }
+  if listing.Dirs[0].Incomplete != 0 { t.Error() }
+  if len(listing.Dirs[0].Files) != 1 { t.Error() }
+}
+
+func Test_MergePartialListings(t *testing.T) {
+  parse := func(list string) *FileListing {
+    out := &FileListing{}
+    err := ParseFileList(strings.NewReader(list), out)
+    if err != nil { t.Fatal(err) }
+    return out
+  }
+  root := parse(`<FileListing Base="/">
+                   <File Name="a" Size="1" TTH="ttha"/>
+                   <Directory Name="b" Incomplete="1"/>
+                   <Directory Name="c" Incomplete="1"/>
+                 </FileListing>`)
+  root.Incomplete = 0
+  if root.unexplored("/") { t.Error() }
+  if !root.unexplored("/b") { t.Error() }
+  if !root.unexplored("/b/x/y") { t.Error() }
+  if root.unexplored("/missing") { t.Error() }
+
+  merged := root.merge("/b/", parse(`<FileListing Base="/b/">
+                                      <File Name="d" Size="1" TTH="tthd"/>
+                                      <Directory Name="e" Incomplete="1"/>
+                                    </FileListing>`))
+  if merged.unexplored("/b") { t.Error() }
+  if !merged.unexplored("/b/e") { t.Error() }
+  if !merged.unexplored("/c") { t.Error() }
+  if _, err := merged.FindFile("/b/d"); err != nil { t.Error(err) }
+  if _, err := merged.FindFile("/a"); err != nil { t.Error(err) }
+
+  /* the original listing is left alone */
+  if !root.unexplored("/b") { t.Error() }
+  if _, err := root.FindFile("/b/d"); err == nil { t.Error() }
+
+  /* refreshing the root keeps explored subtrees */
+  merged = merged.merge("/", parse(`<FileListing Base="/">
+                                     <Directory Name="b" Incomplete="1"/>
+                                     <Directory Name="c" Incomplete="1"/>
+                                   </FileListing>`))
+  if _, err := merged.FindFile("/b/d"); err != nil { t.Error(err) }
+  if _, err := merged.FindFile("/a"); err == nil { t.Error() }
+
+  /* directories on the way to the base are created as needed */
+  merged = merged.merge("/x/y/", parse(`<FileListing Base="/x/y/">
+                                         <File Name="z" Size="1" TTH="z"/>
+                                       </FileListing>`))
+  if _, err := merged.FindFile("/x/y/z"); err != nil { t.Error(err) }
+  if !merged.unexplored("/x") { t.Error() }
+  dir, err := merged.FindDir("/b")
+  if err != nil { t.Fatal(err) }
+  if !dir.incomplete() { t.Error() }
+  dir, err = merged.FindDir("/x/y")
+  if err != nil { t.Fatal(err) }
+  if dir.incomplete() { t.Error() }
+}
+
+func Test_DirectoryIndex(t *testing.T) {
+  d := NewDirectory("d", "")
+  for i := 0; i < 3 * indexThreshold; i++ {
+    d.addDir(Directory{Name: "d" + strconv.Itoa(i)})
+    d.addFile(&File{Name: "f" + strconv.Itoa(i)})
+  }
+  check := func(i int, present bool) {
+    dir, file := d.childDir("d" + strconv.Itoa(i)), d.childFile("f" + strconv.Itoa(i))
+    if (dir != nil) != present || (file != nil) != present {
+      t.Errorf("%d: expected present = %v", i, present)
+    }
+    if dir != nil && dir.Name != "d" + strconv.Itoa(i) { t.Error(dir.Name) }
+    if file != nil && file.Name != "f" + strconv.Itoa(i) { t.Error(file.Name) }
+  }
+  for i := 0; i < 3 * indexThreshold; i++ {
+    check(i, true)
+  }
+  if d.dirIdx == nil || d.fileIdx == nil { t.Error() }
+
+  /* removals move the last entry around */
+  d.removeDirName("d0")
+  d.removeFileName("f0")
+  d.removeDir(5)
+  d.removeFile(5)
+  for i := 0; i < 3 * indexThreshold; i++ {
+    check(i, i != 0 && i != 5)
+  }
+
+  /* sorting rearranges everything */
+  sort.Sort(&d)
+  for i := 0; i < 3 * indexThreshold; i++ {
+    check(i, i != 0 && i != 5)
+  }
+
+  /* parsed listings are indexed up front, so lookups only ever read */
+  var buf bytes.Buffer
+  buf.WriteString(`<FileListing Base="/"><Directory Name="sub">`)
+  for i := 0; i < indexThreshold; i++ {
+    buf.WriteString(`<File Name="f` + strconv.Itoa(i) + `" Size="1"/>`)
+  }
+  buf.WriteString(`</Directory></FileListing>`)
+  var listing FileListing
+  if err := ParseFileList(&buf, &listing); err != nil { t.Fatal(err) }
+  sub := listing.childDir("sub")
+  if sub == nil || sub.fileIdx == nil { t.Fatal(sub) }
+  merged := listing.merge("/sub/x/", &FileListing{})
+  if merged.childDir("sub").fileIdx == nil { t.Error() }
+}
@@ -0,0 +1,86 @@
+package dc
+
+import "strings"
+import "unicode/utf8"
+
+/* Names in a peer's listing are chosen by the peer, so they can't be trusted
+ * to be usable as paths here. Every component of a path is cleaned up on its
+ * own: anything which would move around the directory tree is dropped, and
+ * characters which some filesystems can't store are replaced. */
+
+/* The longest a name can be, most filesystems stop at 255 bytes */
+const MaxNameLength = 255
+
+/* Windows refuses to create files with these names, whatever the extension */
+var reservedNames = map[string]bool{
+  "CON": true, "PRN": true, "AUX": true, "NUL": true,
+  "COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+  "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+  "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+  "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+/* Turns a path from a listing into a relative path with only safe names,
+ * which may be empty if nothing in it was usable */
+func SafePath(pathname string) string {
+  parts := strings.FieldsFunc(pathname, func(r rune) bool {
+    return r == '/' || r == '\\'
+  })
+  safe := make([]string, 0, len(parts))
+  for _, part := range parts {
+    if strings.TrimRight(part, ". ") == "" { continue } /* ".", ".." etc. */
+    safe = append(safe, SafeName(part))
+  }
+  return strings.Join(safe, "/")
+}
+
+/* Makes a single name safe to create in a directory. Separators and
+ * characters which are illegal on common filesystems become underscores, and
+ * names which would refer to somewhere else are replaced entirely. */
+func SafeName(name string) string {
+  out := make([]byte, 0, len(name))
+  for i, w := 0, 0; i < len(name); i += w {
+    r, size := utf8.DecodeRuneInString(name[i:])
+    w = size
+    switch {
+    case r == utf8.RuneError && size <= 1,
+         r < 0x20, r == 0x7f,
+         strings.ContainsRune(`/\<>:"|?*`, r):
+      out = append(out, '_')
+    default:
+      out = append(out, name[i:i + size]...)
+    }
+  }
+  name = string(out)
+
+  /* Windows drops trailing dots and spaces, which also takes care of "."
+   * and ".." */
+  name = strings.TrimRight(name, ". ")
+  if name == "" {
+    return "_"
+  }
+  base := name
+  if idx := strings.Index(base, "."); idx != -1 {
+    base = base[:idx]
+  }
+  if reservedNames[strings.ToUpper(base)] {
+    name = "_" + name
+  }
+  return truncateName(name)
+}
+
+/* Shortens a name to MaxNameLength bytes, keeping its extension if it's a
+ * reasonable length and never splitting a character */
+func truncateName(name string) string {
+  if len(name) <= MaxNameLength { return name }
+  ext := ""
+  if idx := strings.LastIndex(name, "."); idx > 0 && len(name) - idx <= 16 {
+    ext = name[idx:]
+    name = name[:idx]
+  }
+  keep := MaxNameLength - len(ext)
+  for keep > 0 && !utf8.RuneStart(name[keep]) {
+    keep--
+  }
+  return name[:keep] + ext
+}
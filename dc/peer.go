@@ -11,6 +11,7 @@ import "math/rand"
 import "os"
 import "regexp"
 import "strconv"
+import "strings"
 import "sync"
 
 type peer struct {
@@ -26,7 +27,12 @@ type peer struct {
   dl    *download
   file  *os.File
   ul    *File
+  src   io.ReadSeeker
+  free  bool
   dls   []*download
+  turn  uint64 /* when a download from this peer was last started */
+  dropped bool /* whether we hung up on them */
+  xfer  *transfer
 }
 
 type peerState int
@@ -42,6 +48,7 @@ const (
 
 var NotIdle = errors.New("client not idle")
 var ClientFileNotFound = errors.New("file not found")
+var PeerGone = errors.New("peer disconnected")
 
 func (c *Client) peer(nick string, cb func(*peer)) *peer {
   c.Lock()
@@ -58,6 +65,13 @@ func (c *Client) peer(nick string, cb func(*peer)) *peer {
   return p
 }
 
+/* Whether the connection went away because we hung up */
+func (p *peer) hungUp() bool {
+  p.Lock()
+  defer p.Unlock()
+  return p.dropped
+}
+
 func (c *Client) peerGone(nick string) {
   c.Lock()
   p := c.peers[nick]
@@ -65,27 +79,68 @@ func (c *Client) peerGone(nick string) {
     panic("removing unknown peer")
   }
   delete(c.peers, nick)
+  /* if we hung up to stop a download, everything else waits for a new
+   * connection rather than failing */
+  requeue := make([]*download, 0)
+  reach := make([]string, 0)
   for _, dl := range p.dls {
-    c.failed = append(c.failed, dl)
+    if p.dropped {
+      requeue = append(requeue, dl)
+    } else {
+      c.fail(dl, PeerGone)
+    }
   }
   if p.file != nil {
     p.file.Close()
-    if p.dl != nil {
-      os.Remove(p.file.Name())
+    /* Partial files are kept around to pick up where they left off, and
+     * segments leave that to the download they're a part of */
+    if p.dl != nil && p.dl.parent == nil {
+      if p.dl.fileList() || p.dl.purge {
+        os.Remove(p.file.Name())
+      } else {
+        p.dl.resumeFrom(p.file.Name())
+      }
     }
     p.file = nil
   }
   if p.dl != nil {
-    c.failed = append(c.failed, p.dl)
+    switch {
+    case p.dl.parent != nil:
+      var again bool
+      again, reach = c.segmentGone(p.dl)
+      if again {
+        requeue = append(requeue, p.dl.parent)
+      }
+    case p.dl.stop == Canceled:
+      p.dl.finish(Canceled)
+    case p.dl.stop == Paused || p.dl.stop == Stopping:
+      requeue = append(requeue, p.dl)
+    default:
+      /* what they were in the middle of may be had from someone else */
+      if c.alternate(p.dl) {
+        requeue = append(requeue, p.dl)
+      } else {
+        c.fail(p.dl, PeerGone)
+      }
+    }
+    p.dl.stop = nil
     c.DL.release()
     p.dl = nil
   } else if p.ul != nil {
-    if p.ul.Name != "files.xml.bz2" {
+    if !p.free {
       c.UL.release()
     }
     p.ul = nil
+    p.src = nil
   }
   c.Unlock()
+  for _, dl := range requeue {
+    c.download(dl)
+  }
+  for _, nick := range reach {
+    c.reach(nick)
+  }
+  c.saveQueue()
   c.initiateDownload()
   p.dead <- 0
 }
@@ -96,26 +151,92 @@ func (c *Client) initiateDownload() (err error) {
     if err != nil { c.DL.release() }
   }()
 
+  skipped := false
+  defer func() {
+    if skipped { c.saveQueue() }
+  }()
+  reach := make([]string, 0)
+  defer func() {
+    for _, nick := range reach {
+      c.reach(nick)
+    }
+  }()
+  requeue := make([]*download, 0)
+  defer func() {
+    for _, dl := range requeue {
+      c.download(dl)
+    }
+  }()
   c.Lock()
   defer c.Unlock()
 
+  /* those which can't be split up any more go back to one source at a time */
+  for _, dl := range c.stranded() {
+    if c.regroup(dl, "") {
+      requeue = append(requeue, dl)
+    }
+  }
+
   /* If we can't create our destination file, then this is a fatal error. If we
    * can't actually download a file from anyone because everyone's already
-   * downloading, then this isn't fatal. */
+   * downloading, then this isn't fatal. Files which are already there and
+   * shouldn't be touched are just dropped from the queue. Downloads which
+   * have other sources are split up, and then whoever's free among them is
+   * handed a part. */
   var dl *download
-  for _, peer := range c.peers {
-    dl = peer.pop()
-    if dl == nil { continue }
-    dst, err := dl.destination(c.DownloadRoot)
-    if err != nil { return err }
-    file, err := os.Create(dst)
+  busy := make(map[*peer]bool)
+  for {
+    var peer *peer
+    peer, dl = c.next(busy)
+    if dl == nil { break }
+    if !c.segmenting(dl) {
+      peer.Lock()
+      peer.remove(dl)
+      peer.Unlock()
+      file, err := dl.open(c.DownloadRoot)
+      if err == AlreadyExists {
+        c.log("Already have " + dl.file + ", skipping it")
+        dl.finish(nil)
+        skipped = true
+        continue
+      }
+      if err != nil { return err }
+      c.findSources(dl)
+      if !dl.segmentable(peer) {
+        dl.flatten() /* they can't be asked for parts of it */
+      }
+      if dl.segmentable(peer) {
+        file.Close()
+        /* it may have been finished off while it was paused */
+        if dl.parts != nil && dl.parts.complete() {
+          c.startVerify(dl)
+          continue
+        }
+        reach = append(reach, c.segment(dl)...)
+      } else if peer.download(file, dl) == nil {
+        c.turns++
+        peer.turn = c.turns
+        break
+      } else {
+        file.Close()
+        if dl.offset == 0 {
+          os.Remove(file.Name())
+        }
+        peer.Lock()
+        peer.push(dl)
+        peer.Unlock()
+        busy[peer] = true
+        continue
+      }
+    }
+    started, err := c.startSegment(peer, dl)
     if err != nil { return err }
-    if peer.download(file, dl) == nil {
+    if started {
+      c.turns++
+      peer.turn = c.turns
       break
     }
-    os.Remove(dst)
-    peer.push(dl)
-    dl = nil
+    busy[peer] = true
   }
   /* if we didn't start a download with anyone, then release the slot we got */
   if dl == nil {
@@ -125,15 +246,6 @@ func (c *Client) initiateDownload() (err error) {
   return nil
 }
 
-func (p *peer) pop() *download {
-  if len(p.dls) == 0 {
-    return nil
-  }
-  dl := p.dls[0]
-  p.dls = p.dls[1:]
-  return dl
-}
-
 func (p *peer) push(dl *download) {
   p.dls = append(p.dls, dl)
 }
@@ -146,7 +258,13 @@ func (p *peer) download(outfile *os.File, dl *download) error {
   if p.write == nil { panic("idle without a write connection!") }
 
   if dl.fileList() {
-    if p.implements("XmlBZList") {
+    if dl.dir != "" && !(p.implements("ADCGet") && p.implements("PartialList")) {
+      dl.dir = ""
+      dl.recursive = false
+    }
+    if dl.dir != "" {
+      /* the directory is requested instead */
+    } else if p.implements("XmlBZList") {
       dl.file = "files.xml.bz2"
     } else if p.implements("BZList") {
       dl.file = "MyList.bz2"
@@ -158,18 +276,38 @@ func (p *peer) download(outfile *os.File, dl *download) error {
   p.state = Downloading
   p.file = outfile
   p.dl = dl
+  p.request(dl)
+  return nil
+}
+
+/* Asks for whatever is needed next to complete a download. That's the leaves
+ * of the file's tree if they can be checked against, then the file itself,
+ * and then any blocks of it which turned out to be corrupt. */
+func (p *peer) request(dl *download) {
+  offset, size := dl.offset, dl.size
+  if len(dl.bad) > 0 {
+    offset, size = dl.segment(dl.bad[0])
+  }
 
   if p.implements("ADCGet") {
     sendf(p.write, "ADCGET", func(w *bufio.Writer) {
-      if dl.tth != "" && p.implements("TTHF") {
+      if dl.dir != "" {
+        fmt.Fprintf(w, "list %s", dl.dir)
+      } else if dl.wantLeaves(p) {
+        fmt.Fprintf(w, "tthl TTH/%s", dl.tth)
+        offset, size = 0, -1
+      } else if dl.tth != "" && p.implements("TTHF") {
         fmt.Fprintf(w, "file TTH/%s", dl.tth)
       } else {
         fmt.Fprintf(w, "file %s", dl.file)
       }
-      fmt.Fprintf(w, " %d %d", dl.offset, dl.size)
+      fmt.Fprintf(w, " %d %d", offset, size)
       if p.implements("ZLIG") {
         w.WriteString(" ZL1")
       }
+      if dl.recursive {
+        w.WriteString(" RE1")
+      }
     })
   } else if p.implements("GetZBlock") {
     sendf(p.write, "UGetZBlock", func(w *bufio.Writer) {
@@ -184,15 +322,21 @@ func (p *peer) download(outfile *os.File, dl *download) error {
       fmt.Fprintf(w, "%s$%d", dl.file, dl.offset + 1)
     })
   }
-  return nil
 }
 
 func (p *peer) upload(c *Client, file string,
                       offset, size int64) (int64, error) {
   err := ClientFileNotFound
 
-  /* MiniSlots dictates that file lists don't need upload slots */
-  if file != "files.xml.bz2" {
+  p.Lock()
+  defer p.Unlock()
+  if p.state != Idle { return 0, NotIdle }
+  info := c.shares.query(file)
+  if info == nil { return 0, ClientFileNotFound }
+
+  /* MiniSlots dictates that file lists don't need upload slots. The list may
+   * also have been requested by its TTH, so go by what was found */
+  if info.Name != FileList {
     /* take a slot, convert to upload state, set p.ul with open file */
     if !c.UL.take() { return 0, errors.New("No slots to upload with") }
     defer func() {
@@ -200,22 +344,69 @@ func (p *peer) upload(c *Client, file string,
     }()
   }
 
-  p.Lock()
-  defer p.Unlock()
-  if p.state != Idle { return 0, NotIdle }
-  info := c.shares.query(file)
-  if info == nil { return 0, ClientFileNotFound }
   handle, err := os.Open(info.realpath)
   if err != nil { return 0, err }
 
   p.state = Uploading
   p.ul = info
   p.file = handle
+  p.src = handle
+  p.free = info.Name == FileList
   err = nil
-  if offset + size > int64(info.Size) || size == -1 {
-    return int64(info.Size) - offset, nil
+  return span(int64(info.Size), offset, size), nil
+}
+
+/* Prepares to upload a partial file list of the given directory, generated on
+ * the fly. Lists never need an upload slot. */
+func (p *peer) uploadList(c *Client, dir string, recursive bool,
+                          offset, size int64) (int64, error) {
+  p.Lock()
+  defer p.Unlock()
+  if p.state != Idle { return 0, NotIdle }
+  data, err := c.shares.partialList(dir, recursive)
+  if err != nil { return 0, err }
+
+  p.state = Uploading
+  p.ul = &File{Name: "list of " + dir, Size: ByteSize(len(data))}
+  p.src = bytes.NewReader(data)
+  p.free = true
+  return span(int64(len(data)), offset, size), nil
+}
+
+/* Prepares to upload the leaves of the hash tree of a shared file. They're
+ * small and let the peer check what it downloads, so no slot is needed. */
+func (p *peer) uploadLeaves(c *Client, file string,
+                            offset, size int64) (int64, error) {
+  p.Lock()
+  defer p.Unlock()
+  if p.state != Idle { return 0, NotIdle }
+  info := c.shares.query(file)
+  if info == nil || info.TTH == "" || info.TTH == "fail" {
+    return 0, ClientFileNotFound
   }
-  return size, nil
+  data, err := loadLeaves(c.CacheDir, info.TTH)
+  if err != nil { return 0, err }
+
+  p.state = Uploading
+  p.ul = &File{Name: "leaves of " + info.Name, Size: ByteSize(len(data))}
+  p.src = bytes.NewReader(data)
+  p.free = true
+  return span(int64(len(data)), offset, size), nil
+}
+
+/* How much of something of the given total size a request covers */
+func span(total, offset, size int64) int64 {
+  if offset + size > total || size == -1 {
+    return total - offset
+  }
+  return size
+}
+
+func hasFlag(flags []string, flag string) bool {
+  for _, f := range flags {
+    if f == flag { return true }
+  }
+  return false
 }
 
 func (p *peer) implements(extension string) bool {
@@ -227,15 +418,30 @@ func (p *peer) implements(extension string) bool {
   return false
 }
 
-func (p *peer) parseFiles(c *Client, in io.Reader) error {
+/* Whole file lists replace what we knew about the peer, while partial lists
+ * are merged into it */
+func (p *peer) parseFiles(c *Client, in io.Reader, dl *download) error {
   files := &FileListing{}
-  err := ParseFileList(bzip2.NewReader(in), files)
-  if err == nil {
-    c.Lock()
-    c.lists[p.nick] = files
-    c.Unlock()
+  if dl.dir == "" {
+    in = bzip2.NewReader(in)
   }
-  return err
+  err := ParseFileList(in, files)
+  if err != nil { return err }
+
+  c.Lock()
+  if dl.dir != "" {
+    prev := c.lists[p.nick]
+    if prev == nil {
+      prev = &FileListing{Version: files.Version, Base: "/",
+                          Generator: files.Generator, CID: files.CID}
+      prev.Name = "/"
+      prev.Incomplete = 1
+    }
+    files = prev.merge(dl.dir, files)
+  }
+  c.lists[p.nick] = files
+  c.Unlock()
+  return nil
 }
 
 func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
@@ -272,6 +478,9 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
   p.in = in
   p.out = out
   defer c.peerGone(p.nick)
+  defer func() {
+    if p.hungUp() { err = nil }
+  }()
 
   c.log("Connected to: " + p.nick)
   defer c.log("Disconnected from: " + p.nick)
@@ -290,7 +499,7 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
     })
   }
   send(write, "Supports",
-       []byte("MiniSlots XmlBZList ADCGet ZLIG GetZBlock TTHF"))
+       []byte("MiniSlots XmlBZList ADCGet ZLIG GetZBlock TTHF TTHL PartialList"))
   mydirection := "Upload"
   if len(p.dls) > 0 {
     mydirection = "Download"
@@ -322,24 +531,41 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
   }
 
   /* Step 7+ - upload/download files infinitely until closed */
+  p.Lock()
   p.write = write
   p.state = Idle
+  p.Unlock()
 
   /* try to diagnose why peers disconnect */
   err = c.initiateDownload()
   defer func() {
-    if err != nil && err != io.EOF {
+    if err != nil && err != io.EOF && !p.hungUp() {
       c.log("error with '" + nick + "': " + err.Error())
     }
   }()
 
+  /* Fails the current download without dropping the connection */
+  abandon := func(reason error) error {
+    p.file.Close()
+    os.Remove(p.file.Name())
+    p.dl.restart()
+    c.Lock()
+    c.fail(p.dl, reason)
+    c.Unlock()
+    c.DL.release()
+    p.dl = nil
+    p.file = nil
+    p.state = Idle
+    c.saveQueue()
+    return c.initiateDownload()
+  }
+
   dl := func(size int64, offset int64, z bool) error {
     if p.state != Downloading {
       return errors.New("not in the downloading state")
     }
     if p.dl == nil { return errors.New("downloading with nil download") }
     if p.ul != nil { return errors.New("downloading while uploading") }
-    defer p.file.Close()
 
     var input io.Reader = buf
     if z {
@@ -356,29 +582,115 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
     }
     if err != nil { return err }
 
+    /* segments stop short if someone else takes over the rest */
+    var output io.Writer = p.file
+    if p.dl.seg != nil {
+      if offset != p.dl.seg.start {
+        return errors.New("wrong part of " + p.dl.file + " sent")
+      }
+      output = &segmentWriter{w: p.file, seg: p.dl.seg}
+    }
+
     c.log("Starting download of: " + p.dl.file)
-    s, err := io.CopyN(p.file, input, size)
+    xfer := p.track(DownloadTransfer, p.dl.file, offset, size, p.dl.total())
+    s, err := io.CopyN(output, xfer.reader(input), size)
+    p.untrack()
+    if p.dl.seg != nil && (err == nil || err == Stolen) {
+      return c.endSegment(p, err == Stolen)
+    }
     if err != nil { return err }
     if s != size { return errors.New("Didn't download whole file") }
+
+    /* Corrupt blocks are asked for again, the file stays open meanwhile */
+    if p.dl.leaves != nil {
+      corrupt, err := p.dl.check(p.file, offset, size)
+      if err != nil { return err }
+      if corrupt > 0 {
+        c.log(fmt.Sprintf("%d corrupt blocks of %s from %s", corrupt,
+                          p.dl.file, p.nick))
+        p.dl.corrupt++
+        if p.dl.corrupt >= CorruptLimit {
+          c.log("giving up on " + p.dl.file + ": " + p.nick + " " +
+                CorruptData.Error())
+          return abandon(CorruptData)
+        }
+      }
+      if len(p.dl.bad) > 0 {
+        p.Lock()
+        p.request(p.dl)
+        p.Unlock()
+        return nil
+      }
+    }
     if p.dl.fileList() {
       _, err := p.file.Seek(0, os.SEEK_SET)
       if err != nil { return err }
-      err = p.parseFiles(c, p.file)
+      err = p.parseFiles(c, p.file, p.dl)
+      if err != nil { return err }
+    }
+    p.file.Close()
+    verify := p.dl.tth != "" && p.dl.size >= 0 && !p.dl.fileList()
+    if verify {
+      c.Lock()
+      c.startVerify(p.dl)
+      c.Unlock()
+    } else {
+      err = p.dl.commit(c.DownloadRoot)
       if err != nil { return err }
+      p.dl.finish(nil)
+      c.log("Finished downloading: " + p.dl.file)
     }
-    c.log("Finished downloading: " + p.dl.file)
     c.DL.release() /* if we fail with error, our slot is released elsewhere */
     p.dl = nil
     p.file = nil
     p.state = Idle
+    if !verify {
+      c.saveQueue()
+    }
     return c.initiateDownload()
   }
 
+  /* The leaves of a file's tree, which are kept with the download */
+  leaves := func(size int64, z bool) error {
+    if p.state != Downloading || p.dl == nil {
+      return errors.New("not in the downloading state")
+    }
+    if err := p.dl.checkLeafSize(size); err != nil {
+      p.dl.unverified = true
+      return errors.New("bad size of leaves for " + p.dl.file)
+    }
+    var input io.Reader = buf
+    if z {
+      input, err = zlib.NewReader(input)
+      if err != nil { return err }
+    }
+    data := make([]byte, size)
+    _, err := io.ReadFull(input, data)
+    if err != nil { return err }
+
+    if err = p.dl.setLeaves(data); err != nil {
+      c.log("bad leaves of " + p.dl.file + " from " + p.nick + ": " +
+            err.Error())
+      p.dl.unverified = true
+    }
+    if p.dl.parent != nil {
+      c.shareLeaves(p.dl)
+    }
+    /* whoever asked for the leaves may still be finishing up with the
+     * connection, and holds the lock until it's done */
+    p.Lock()
+    p.request(p.dl)
+    p.Unlock()
+    return nil
+  }
+
   ul := func(size int64, offset int64, z bool) error {
     if p.state != Uploading { return errors.New("not in the uploading state") }
     if p.dl != nil { return errors.New("uploading while trying to download") }
     if p.ul == nil { return errors.New("uploading without a file") }
-    defer p.file .Close()
+    if p.file != nil {
+      defer p.file.Close()
+    }
 
     /* Don't upload through the bufio.Writer instance */
     var compressed *zlib.Writer
@@ -389,26 +701,34 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
     }
     write.Flush()
 
-    _, err := p.file.Seek(offset, os.SEEK_SET)
+    _, err := p.src.Seek(offset, os.SEEK_SET)
     if err != nil { return err }
 
-    c.log("Starting upload of: " + p.file.Name())
-    _, err = io.CopyN(upload, p.file, size)
+    name := p.ul.Name
+    if p.file != nil {
+      name = p.file.Name()
+    }
+    c.log("Starting upload of: " + name)
+    xfer := p.track(UploadTransfer, name, offset, size, -1)
+    _, err = io.CopyN(xfer.writer(upload), p.src, size)
+    p.untrack()
     if compressed != nil && err == nil {
       err = compressed.Close() /* be sure to flush the zlib stream */
     }
     if err != nil { return err }
-    c.log("Finished uploading: " + p.file.Name())
-    if p.ul.Name != "files.xml.bz2" {
+    c.log("Finished uploading: " + name)
+    if !p.free {
       c.UL.release() /* if we fail with error, our slot is released elsewhere */
     }
     p.ul = nil
     p.file = nil
+    p.src = nil
     p.state = Idle
     return c.initiateDownload()
   }
 
-  adc := regexp.MustCompile("([^ ]+) (.+) ([0-9]+) (-?[0-9]+)( ZL1)?")
+  adc := regexp.MustCompile("^([^ ]+) (.+) ([0-9]+) (-?[0-9]+)" +
+                            "((?: [A-Z][A-Z0-9][^ ]*)*)$")
   size, offset := int64(0), int64(0)
 
   for err == nil {
@@ -426,12 +746,23 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
         offset, err = strconv.ParseInt(parts[3], 10, 64)
       }
       if err != nil { return err }
-      zlig := len(parts[5]) != 0
+      flags := strings.Fields(parts[5])
+      zlig := hasFlag(flags, "ZL1")
 
-      if m.name == "ADCSND" {
+      if m.name == "ADCSND" && parts[1] == "tthl" {
+        err = leaves(size, zlig)
+      } else if m.name == "ADCSND" {
         err = dl(size, offset, zlig)
       } else {
-        size, err = p.upload(c, parts[2], offset, size)
+        switch parts[1] {
+        case "list":
+          recursive := hasFlag(flags, "RE1")
+          size, err = p.uploadList(c, parts[2], recursive, offset, size)
+        case "tthl":
+          size, err = p.uploadLeaves(c, parts[2], offset, size)
+        default:
+          size, err = p.upload(c, parts[2], offset, size)
+        }
         if err != nil { return err }
         sendf(write, "ADCSND", func(w *bufio.Writer) {
           fmt.Fprintf(w, "%s %s %d %d", parts[1], parts[2], offset, size)
@@ -454,7 +785,7 @@ func (c *Client) handlePeer(in io.ReadCloser, out io.WriteCloser,
       size, err := strconv.ParseInt(string(m.data), 10, 64)
       if err == nil {
         send(write, "Send", nil)
-        err = dl(size, 0, false)
+        err = dl(size, p.dl.offset, false) /* $Get asked from there on */
       }
 
     /* old school DC download system */
@@ -6,8 +6,12 @@ import "compress/zlib"
 import "io"
 import "io/ioutil"
 import "os"
+import "strconv"
+import "strings"
 import "testing"
 
+import "github.com/alexcrichton/fargo/dc/tth"
+
 func getcmd(t *testing.T, in *bufio.Reader, cmd string, m *method) {
   err := readCmd(in, m)
   if err != nil { t.Fatal(err) }
@@ -87,7 +91,7 @@ func setupPeer(t *testing.T) (*Client, *bufio.Reader, *bufio.Writer,
     err := c.handlePeer(peerin, peerout, false)
     peerin.Close()
     peerout.Close()
-    if err != nil && err != io.EOF { t.Fatal(err) }
+    if err != nil && err != io.EOF { t.Error(err) }
   }()
   stub_file(t, c)
   return c, bufio.NewReader(_in), bufio.NewWriter(_out), _in, _out
@@ -103,8 +107,13 @@ func teardownPeer(t *testing.T, c *Client,
 
 func handshake(t *testing.T, in *bufio.Reader, out *bufio.Writer,
                supports string) {
+  handshakeAs(t, in, out, "bar", supports)
+}
+
+func handshakeAs(t *testing.T, in *bufio.Reader, out *bufio.Writer,
+                 nick string, supports string) {
   var m method
-  xsend(t, out, "$MyNick bar|$Lock foo a|")
+  xsend(t, out, "$MyNick " + nick + "|$Lock foo a|")
 
   getcmd(t, in, "MyNick", &m)
   if string(m.data) != "foo" { t.Error(string(m.data)) }
@@ -141,6 +150,7 @@ func Test_Get(t *testing.T) {
   xsend(t, out, "$FileLength 1|")
   getcmd(t, in, "Send", &m)
   xsend(t, out, "f")
+  xsend(t, out, "$") /* wait for the peer to finish writing the file */
 
   data, err := ioutil.ReadFile(c.DownloadRoot + "/a b")
   if err != nil { t.Fatal(err) }
@@ -164,6 +174,7 @@ func Test_UGetBlock(t *testing.T) {
     t.Fatal(string(m.data))
   }
   xsend(t, out, "$Sending 4|ffff")
+  xsend(t, out, "$") /* wait for the peer to finish writing the file */
 
   data, err := ioutil.ReadFile(c.DownloadRoot + "/a b")
   if err != nil { t.Fatal(err) }
@@ -188,6 +199,7 @@ func Test_UGetZBlock(t *testing.T) {
   }
   xsend(t, out, "$Sending 5|")
   zsend(t, out, "fffff")
+  xsend(t, out, "$") /* wait for the peer to finish writing the file */
 
   data, err := ioutil.ReadFile(c.DownloadRoot + "/a b")
   if err != nil { t.Fatal(err) }
@@ -212,6 +224,7 @@ func Test_ADCSND(t *testing.T) {
   }
   xsend(t, out, "$ADCSND file a b 1 3|")
   xsend(t, out, "fff")
+  xsend(t, out, "$") /* wait for the peer to finish writing the file */
 
   data, err := ioutil.ReadFile(c.DownloadRoot + "/a b")
   if err != nil { t.Fatal(err) }
@@ -236,6 +249,7 @@ func Test_ADCSNDWithZlibButNotCompressed(t *testing.T) {
   }
   xsend(t, out, "$ADCSND file a b 1 2|")
   xsend(t, out, "ff")
+  xsend(t, out, "$") /* wait for the peer to finish writing the file */
 
   data, err := ioutil.ReadFile(c.DownloadRoot + "/a b")
   if err != nil { t.Fatal(err) }
@@ -260,6 +274,7 @@ func Test_ADCSNDWithZlib(t *testing.T) {
   }
   xsend(t, out, "$ADCSND file a b 2 2 ZL1|")
   zsend(t, out, "ff")
+  xsend(t, out, "$") /* wait for the peer to finish writing the file */
 
   data, err := ioutil.ReadFile(c.DownloadRoot + "/a b")
   if err != nil { t.Fatal(err) }
@@ -368,15 +383,118 @@ func Test_UploadViaADCWithTTH(t *testing.T) {
   c.shares.queryWait("foo") /* wait for tth hashes to propogate */
 
   tth := "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI"
-  xsend(t, out, "$ADCGET tthl TTH/" + tth + " 0 -1|")
+  xsend(t, out, "$ADCGET file TTH/" + tth + " 0 -1|")
   getcmd(t, in, "ADCSND", &m)
-  if string(m.data) != "tthl TTH/" + tth + " 0 4" {
+  if string(m.data) != "file TTH/" + tth + " 0 4" {
     t.Fatal(string(m.data))
   }
   data := xread(t, in, 4, false)
   if data != "abcd" { t.Fatal(data) }
 }
 
+/* Leaves of the hash tree are sent for tthl, a small file only has its root */
+func Test_UploadLeaves(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "")
+  big := strings.Repeat("0123456789", 300)
+  err := ioutil.WriteFile(c.DownloadRoot + "/share/big", []byte(big),
+                          os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  c.shares.cmds <- rescan
+  f := c.shares.queryWait("foo/big")
+  if f == nil { t.Fatal() }
+
+  root := "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI"
+  xsend(t, out, "$ADCGET tthl TTH/" + root + " 0 -1|")
+  getcmd(t, in, "ADCSND", &m)
+  if string(m.data) != "tthl TTH/" + root + " 0 24" {
+    t.Fatal(string(m.data))
+  }
+  data := xread(t, in, 24, false)
+  if tth.Encode([]byte(data)) != root { t.Error(data) }
+
+  xsend(t, out, "$ADCGET tthl TTH/" + f.TTH + " 0 -1 ZL1|")
+  getcmd(t, in, "ADCSND", &m)
+  if string(m.data) != "tthl TTH/" + f.TTH + " 0 72 ZL1" {
+    t.Fatal(string(m.data))
+  }
+  data = xread(t, in, 72, true)
+  sum, err := tth.Root([]byte(data))
+  if err != nil || tth.Encode(sum) != f.TTH { t.Error(err) }
+}
+
+/* Partial file lists are generated on the fly */
+func Test_UploadPartialList(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "")
+  c.shares.queryWait("foo")
+
+  if c.UL.Cnt != 1 { t.Fatal(c.UL.Cnt) }
+  xsend(t, out, "$ADCGET list /foo/ 0 -1 RE1|")
+  getcmd(t, in, "ADCSND", &m)
+  parts := strings.Split(string(m.data), " ")
+  if len(parts) != 4 || parts[0] != "list" || parts[1] != "/foo/" {
+    t.Fatal(string(m.data))
+  }
+  if c.UL.Cnt != 1 { t.Fatal(c.UL.Cnt) }
+  size, err := strconv.Atoi(parts[3])
+  if err != nil { t.Fatal(err) }
+
+  var listing FileListing
+  data := xread(t, in, size, false)
+  err = ParseFileList(strings.NewReader(data), &listing)
+  if err != nil { t.Fatal(err) }
+  if listing.Base != "/foo/" { t.Error(listing.Base) }
+  if len(listing.Files) != 1 || listing.Files[0].Name != "a b" {
+    t.Fatal(listing.Files)
+  }
+  if listing.Files[0].TTH != "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI" {
+    t.Error(listing.Files[0].TTH)
+  }
+}
+
+/* Directories are fetched lazily from peers supporting partial lists */
+func Test_FetchPartialList(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet PartialList")
+
+  done := make(chan error)
+  go func() { done <- c.fetchList("bar", "/a", true) }()
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "list /a/ 0 -1 RE1" { t.Fatal(string(m.data)) }
+
+  list := `<FileListing Base="/a/"><File Name="f" Size="1" TTH="x"/>` +
+          `<Directory Name="b"><File Name="g" Size="2" TTH="y"/></Directory>` +
+          `</FileListing>`
+  xsend(t, out, "$ADCSND list /a/ 0 " + strconv.Itoa(len(list)) + "|")
+  xsend(t, out, list)
+  if err := <-done; err != nil { t.Fatal(err) }
+
+  dir, err := c.CachedListings("bar", "/a/b")
+  if err != nil { t.Fatal(err) }
+  if len(dir.Files) != 1 || dir.Files[0].Name != "g" { t.Error(dir.Files) }
+  if !c.lists["bar"].unexplored("/") { t.Error() }
+  if c.lists["bar"].unexplored("/a/b") { t.Error() }
+}
+
+/* Peers without partial lists get asked for the whole thing */
+func Test_FetchListFallback(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet XmlBZList")
+
+  go c.fetchList("bar", "/a", false)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file files.xml.bz2 0 -1" { t.Fatal(string(m.data)) }
+}
+
 /* Test MiniSlots support */
 func Test_SupportsMiniSlots(t *testing.T) {
   var m method
@@ -426,3 +544,159 @@ func Test_SupportsDownloadSlots(t *testing.T) {
   xsend(t, out, "$")
   if c.DL.Cnt != 1 { t.Fatal(c.DL.Cnt) }
 }
+
+/* Leaves are fetched first and corrupt blocks are asked for again */
+func Test_RepairCorruptBlocks(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet TTHF TTHL")
+
+  data := strings.Repeat("0123456789", 300)
+  root, leaves := leavesOf(t, data, 0)
+  dl := NewDownloadFile("bar", "/f", &File{Size: 3000, TTH: root})
+  dl.done = make(chan error, 1)
+  go c.download(dl)
+
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "tthl TTH/" + root + " 0 -1" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$ADCSND tthl TTH/" + root + " 0 72|")
+  xsend(t, out, string(leaves))
+
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file TTH/" + root + " 0 3000" {
+    t.Fatal(string(m.data))
+  }
+  xsend(t, out, "$ADCSND file TTH/" + root + " 0 3000|")
+  xsend(t, out, data[:1500] + "x" + data[1501:])
+
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file TTH/" + root + " 1024 1024" {
+    t.Fatal(string(m.data))
+  }
+  xsend(t, out, "$ADCSND file TTH/" + root + " 1024 1024|")
+  xsend(t, out, data[1024:2048])
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+
+  contents, err := ioutil.ReadFile(c.DownloadRoot + "/f")
+  if err != nil { t.Fatal(err) }
+  if string(contents) != data { t.Error("file wasn't repaired") }
+}
+
+/* Peers which keep sending corrupt data are given up on */
+func Test_CorruptPeer(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet TTHF TTHL")
+
+  data := strings.Repeat("0123456789", 300)
+  root, leaves := leavesOf(t, data, 0)
+  dl := NewDownloadFile("bar", "/f", &File{Size: 3000, TTH: root})
+  dl.done = make(chan error, 1)
+  go c.download(dl)
+
+  getcmd(t, in, "ADCGET", &m)
+  xsend(t, out, "$ADCSND tthl TTH/" + root + " 0 72|")
+  xsend(t, out, string(leaves))
+  getcmd(t, in, "ADCGET", &m)
+  xsend(t, out, "$ADCSND file TTH/" + root + " 0 3000|")
+  xsend(t, out, strings.Repeat("x", 3000))
+  for i := 0; i < CorruptLimit - 1; i++ {
+    getcmd(t, in, "ADCGET", &m)
+    parts := strings.Split(string(m.data), " ")
+    if len(parts) != 4 || parts[3] != "1024" { t.Fatal(string(m.data)) }
+    xsend(t, out, "$ADCSND " + string(m.data) + "|")
+    xsend(t, out, strings.Repeat("x", 1024))
+  }
+  if err := <-dl.done; err != CorruptData { t.Error(err) }
+  if _, err := os.Stat(c.DownloadRoot + "/f"); err == nil { t.Error() }
+}
+
+/* Finished downloads are hashed, and downloaded again if they don't match */
+func Test_VerifyDownload(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet TTHF")
+
+  root := "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI" /* "abcd" */
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4, TTH: root})
+  dl.done = make(chan error, 1)
+  go c.download(dl)
+
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file TTH/" + root + " 0 4" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$ADCSND file TTH/" + root + " 0 4|")
+  xsend(t, out, "abce")
+
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file TTH/" + root + " 0 4" { t.Fatal(string(m.data)) }
+  data, err := ioutil.ReadFile(c.DownloadRoot + "/f.corrupt")
+  if err != nil || string(data) != "abce" { t.Fatal(err) }
+  select {
+    case err := <-dl.done: t.Fatal(err)
+    default:
+  }
+
+  xsend(t, out, "$ADCSND file TTH/" + root + " 0 4|")
+  xsend(t, out, "abcd")
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+  data, err = ioutil.ReadFile(c.DownloadRoot + "/f")
+  if err != nil || string(data) != "abcd" { t.Fatal(err) }
+}
+
+/* Dropped downloads keep what they have and resume from there */
+func Test_ResumeDownload(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet")
+
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4})
+  dl.done = make(chan error, 1)
+  go c.download(dl)
+
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 4" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$ADCSND file f 0 4|")
+  xsend(t, out, "ab")
+  _out.Close()
+  if err := <-dl.done; err != PeerGone { t.Fatal(err) }
+  if dl.offset != 2 || dl.size != 2 { t.Fatal(dl.offset, dl.size) }
+  data, err := ioutil.ReadFile(c.DownloadRoot + "/f" + TempSuffix)
+  if err != nil || string(data) != "ab" { t.Fatal(err) }
+  if _, err := os.Stat(c.DownloadRoot + "/f"); err == nil { t.Error() }
+
+  file, err := dl.open(c.DownloadRoot)
+  if err != nil { t.Fatal(err) }
+  defer file.Close()
+  if file.Name() != c.DownloadRoot + "/f" + TempSuffix { t.Error(file.Name()) }
+  info, err := file.Stat()
+  if err != nil || info.Size() != 2 { t.Error(err) }
+}
+
+/* Peers without ADCGet are asked for the rest of the file with $Get */
+func Test_ResumeGet(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "")
+
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4})
+  dl.dst = c.DownloadRoot + "/f"
+  dl.offset, dl.size = 2, 2
+  dl.done = make(chan error, 1)
+  err := ioutil.WriteFile(dl.temp(), []byte("ab"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  go c.download(dl)
+
+  getcmd(t, in, "Get", &m)
+  if string(m.data) != "f$3" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$FileLength 2|")
+  getcmd(t, in, "Send", &m)
+  xsend(t, out, "cd")
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+  data, err := ioutil.ReadFile(c.DownloadRoot + "/f")
+  if err != nil || string(data) != "abcd" { t.Error(string(data), err) }
+}
@@ -0,0 +1,250 @@
+package dc
+
+import "encoding/gob"
+import "os"
+import "path/filepath"
+import "sort"
+import "time"
+
+/* The download queue is remembered in the cache directory so that it survives
+ * restarts. Partially downloaded files are kept, and when the queue is loaded
+ * again they're picked up from however much made it to disk. */
+type queueEntry struct {
+  ID     uint64
+  Nick   string
+  File   string
+  TTH    string
+  Size   int64 /* of the whole file */
+  Root   string
+  Reldst string
+  Dest   string
+  Done   int64
+  Failed bool
+  Conflict ConflictPolicy
+  Priority Priority
+  Unpaused Priority
+  Failures int
+  Reason   string
+  RetryAt  time.Time
+  Sources  []Source
+  Segmented bool /* so only Done is trusted, the file may have gaps */
+}
+
+type queueFile struct {
+  Version int
+  Entries []queueEntry
+}
+
+const queueVersion = 1
+const queueName = "queue.db"
+
+/* Every file download we know about in the order they were queued, those which
+ * failed last. The client must be locked. */
+func (c *Client) queued() []*download {
+  dls := make([]*download, 0)
+  seen := make(map[*download]bool)
+  add := func(dl *download) {
+    if !dl.fileList() && !seen[dl] {
+      seen[dl] = true
+      dls = append(dls, dl)
+    }
+  }
+  for _, p := range c.peers {
+    if p.dl != nil {
+      add(p.dl.item())
+    }
+    for _, dl := range p.dls {
+      add(dl)
+    }
+  }
+  for _, dl := range c.segmented {
+    add(dl)
+  }
+  for _, dl := range c.verifying {
+    add(dl)
+  }
+  sort.Slice(dls, func(i, j int) bool { return dls[i].id < dls[j].id })
+  for _, dl := range c.failed {
+    if !dl.fileList() {
+      dls = append(dls, dl)
+    }
+  }
+  return dls
+}
+
+/* Writes out the queue, logging rather than failing since the downloads
+ * themselves are unaffected */
+func (c *Client) saveQueue() {
+  if c.CacheDir == "" { return }
+  c.Lock()
+  dls := c.queued()
+  failed := make(map[*download]bool)
+  for _, dl := range c.failed {
+    failed[dl] = true
+  }
+  contents := queueFile{Version: queueVersion,
+                        Entries: make([]queueEntry, len(dls))}
+  for i, dl := range dls {
+    contents.Entries[i] = queueEntry{ID:     dl.id,
+                                     Nick:   dl.nick,
+                                     File:   dl.file,
+                                     TTH:    dl.tth,
+                                     Size:   dl.total(),
+                                     Root:   dl.root,
+                                     Reldst: dl.reldst,
+                                     Dest:   dl.dst,
+                                     Done:   dl.resumable(),
+                                     Failed: failed[dl],
+                                     Conflict: dl.conflict,
+                                     Priority: dl.priority,
+                                     Unpaused: dl.unpaused,
+                                     Failures: dl.failures,
+                                     Reason:   dl.reason,
+                                     RetryAt:  dl.retryAt,
+                                     Sources:  dl.sources,
+                                     Segmented: dl.parts != nil}
+  }
+  c.Unlock()
+
+  err := writeQueue(c.CacheDir, &contents)
+  if err != nil {
+    c.log("queue error: " + err.Error())
+  }
+}
+
+func writeQueue(dir string, contents *queueFile) error {
+  err := os.MkdirAll(dir, os.FileMode(0755))
+  if err != nil { return err }
+  dst := filepath.Join(dir, queueName)
+  file, err := os.Create(dst + ".tmp")
+  if err != nil { return err }
+
+  err = gob.NewEncoder(file).Encode(contents)
+  if err == nil {
+    err = file.Sync()
+  }
+  if err2 := file.Close(); err == nil {
+    err = err2
+  }
+  if err == nil {
+    err = os.Rename(file.Name(), dst)
+  }
+  if err != nil {
+    os.Remove(file.Name())
+  }
+  return err
+}
+
+/* Restores the queue saved by a previous run. Downloads wait for the hub to
+ * be connected to before asking their peers for connections. */
+func (c *Client) LoadQueue() error {
+  file, err := os.Open(filepath.Join(c.CacheDir, queueName))
+  if os.IsNotExist(err) { return nil }
+  if err != nil { return err }
+  defer file.Close()
+
+  var contents queueFile
+  err = gob.NewDecoder(file).Decode(&contents)
+  if err != nil { return err }
+  if contents.Version != queueVersion { return nil }
+
+  for _, e := range contents.Entries {
+    dl := &download{nick: e.Nick, file: e.File, tth: e.TTH, offset: e.Done,
+                    size: e.Size - e.Done, root: e.Root, reldst: e.Reldst,
+                    dst: e.Dest, conflict: e.Conflict, priority: e.Priority,
+                    unpaused: e.Unpaused, id: e.ID, failures: e.Failures,
+                    reason: e.Reason, sources: e.Sources}
+    if dl.dst != "" {
+      dl.resumeFrom(dl.temp())
+      if e.Segmented && dl.offset > e.Done {
+        dl.offset, dl.size = e.Done, e.Size - e.Done
+      }
+    }
+    if e.Failed {
+      c.Lock()
+      c.identify(dl)
+      c.failed = append(c.failed, dl)
+      /* the wait for a retry carries on from where it was */
+      if dl.failures > 0 && dl.retrying() {
+        delay := time.Until(e.RetryAt)
+        if delay < 0 {
+          delay = 0
+        }
+        c.retryIn(dl, delay)
+      }
+      c.Unlock()
+    } else if err := c.download(dl); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/* Gives a download an ID if it doesn't have one yet, making sure that it's not
+ * handed out again. The client must be locked. */
+func (c *Client) identify(dl *download) {
+  if dl.id == 0 {
+    c.lastID++
+    dl.id = c.lastID
+  } else if dl.id > c.lastID {
+    c.lastID = dl.id
+  }
+}
+
+/* Downloads from nicks who aren't on the hub wait until they show up, and only
+ * then is a connection asked for */
+
+/* Whether a nick could be downloaded from now. The client must be locked. */
+func (c *Client) reachable(nick string) bool {
+  if c.Hub.nicks[nick] != nil { return true }
+  p := c.peers[nick]
+  if p == nil { return false }
+  p.Lock()
+  defer p.Unlock()
+  return p.state != Uninitialized /* on the way to being connected, at least */
+}
+
+/* The peer for a nick, asking for a connection to them if they're around */
+func (c *Client) reach(nick string) *peer {
+  c.Lock()
+  online := c.Hub.nicks[nick] != nil
+  c.Unlock()
+  if !online {
+    return c.peer(nick, func(*peer) {})
+  }
+  return c.peer(nick, c.requestConnection)
+}
+
+/* Picks up with a nick who has just shown up on the hub */
+func (c *Client) joined(nick string) {
+  c.retryNick(nick)
+  c.Lock()
+  waiting := false
+  if p := c.peers[nick]; p != nil {
+    p.Lock()
+    waiting = len(p.dls) > 0
+    p.Unlock()
+  }
+  for _, dl := range c.segmented {
+    waiting = waiting || dl.has(nick)
+  }
+  c.Unlock()
+  if waiting {
+    c.reach(nick)
+  }
+}
+
+/* Lets a nick who leaves be asked for a connection again once they're back,
+ * if they never answered */
+func (c *Client) left(nick string) {
+  c.Lock()
+  delete(c.Hub.nicks, nick)
+  if p := c.peers[nick]; p != nil {
+    p.Lock()
+    if p.state == RequestingConnection {
+      p.state = Uninitialized
+    }
+    p.Unlock()
+  }
+  c.Unlock()
+}
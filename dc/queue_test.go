@@ -0,0 +1,115 @@
+package dc
+
+import "bufio"
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+func Test_QueueRoundTrip(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  c := NewClient()
+  c.Quiet = true
+  c.CacheDir = wd
+  c.DownloadRoot = wd
+
+  /* without a hub, downloads just wait in the queue */
+  partial := &download{nick: "bar", file: "a/b", tth: "x", size: 10,
+                       reldst: "b", dst: filepath.Join(wd, "b")}
+  err := ioutil.WriteFile(partial.temp(), []byte("0123"),
+                          os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  if err := c.download(partial); err != nil { t.Fatal(err) }
+  if err := c.download(NewDownload("baz", "c")); err != nil { t.Fatal(err) }
+  if err := c.download(NewListDownload("baz", "/", false)); err != nil {
+    t.Fatal(err)
+  }
+  c.failed = append(c.failed, NewDownload("bar", "d"))
+  if c.peers["bar"].state != Uninitialized { t.Error(c.peers["bar"].state) }
+  c.saveQueue()
+
+  c = NewClient()
+  c.CacheDir = wd
+  err = c.LoadQueue()
+  if err != nil { t.Fatal(err) }
+  bar, baz := c.peers["bar"], c.peers["baz"]
+  if bar == nil || len(bar.dls) != 1 { t.Fatal(bar) }
+  dl := bar.dls[0]
+  if dl.file != "a/b" || dl.tth != "x" || dl.reldst != "b" {
+    t.Error(dl)
+  }
+  /* the partial file is picked up where it left off */
+  if dl.offset != 4 || dl.size != 6 || dl.dst != partial.dst {
+    t.Error(dl.offset, dl.size, dl.dst)
+  }
+  /* file lists aren't worth remembering */
+  if baz == nil || len(baz.dls) != 1 || baz.dls[0].file != "c" {
+    t.Fatal(baz)
+  }
+  if len(c.failed) != 1 || c.failed[0].file != "d" { t.Error(c.failed) }
+}
+
+func Test_QueueMissing(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  c := NewClient()
+  c.CacheDir = wd
+  if err := c.LoadQueue(); err != nil { t.Error(err) }
+  if len(c.peers) != 0 { t.Error(c.peers) }
+}
+
+func Test_ResumeFrom(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  err := ioutil.WriteFile(wd + "/f", make([]byte, 3000), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+
+  dl := &download{size: 5000}
+  dl.resumeFrom(wd + "/f")
+  if dl.offset != 3000 || dl.size != 2000 { t.Error(dl.offset, dl.size) }
+
+  /* blocks which were bad have to be fetched again */
+  dl = &download{size: 5000, block: 1024, bad: []int64{1}}
+  dl.resumeFrom(wd + "/f")
+  if dl.offset != 1024 || dl.size != 3976 || dl.bad != nil {
+    t.Error(dl.offset, dl.size)
+  }
+  dl = &download{size: 1000}
+  dl.resumeFrom(wd + "/f")
+  if dl.offset != 1000 || dl.size != 0 { t.Error(dl.offset, dl.size) }
+}
+
+/* Nobody is asked for a connection until they're on the hub */
+func Test_WaitForUser(t *testing.T) {
+  var buf bytes.Buffer
+  c := NewClient()
+  c.Quiet = true
+  c.Hub.write = bufio.NewWriter(&buf)
+
+  dl := NewDownload("foo", "a")
+  if err := c.download(dl); err != nil { t.Fatal(err) }
+  if buf.Len() != 0 { t.Error(buf.String()) }
+  queue := c.Queue()
+  if len(queue) != 1 || queue[0].State != Waiting { t.Fatal(queue) }
+  if err := c.fetchList("foo", "/", false); err != UserOffline { t.Error(err) }
+
+  c.Lock()
+  c.Hub.nicks["foo"] = &NickInfo{}
+  c.Unlock()
+  c.joined("foo")
+  if buf.String() != "$RevConnectToMe  foo|" { t.Error(buf.String()) }
+  if c.Queue()[0].State != Queued { t.Error(c.Queue()) }
+
+  /* leaving without ever answering means asking again when they're back */
+  c.left("foo")
+  if c.peers["foo"].state != Uninitialized { t.Error(c.peers["foo"].state) }
+  if c.Queue()[0].State != Waiting { t.Error(c.Queue()) }
+  buf.Reset()
+  c.Lock()
+  c.Hub.nicks["foo"] = &NickInfo{}
+  c.Unlock()
+  c.joined("foo")
+  if buf.String() != "$RevConnectToMe  foo|" { t.Error(buf.String()) }
+}
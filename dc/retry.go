@@ -0,0 +1,128 @@
+package dc
+
+import "time"
+
+/* Downloads which fail wait a while and are then queued up again, waiting
+ * twice as long after each failure. After too many failures they're given up
+ * on until they're retried by hand. A nick coming back to the hub has its
+ * failed downloads retried straight away, since that's usually why they
+ * failed. */
+
+var MaxAttempts = 5
+var RetryDelay = time.Minute
+var MaxRetryDelay = time.Hour
+
+/* How long to wait after the given number of failures */
+func retryDelay(failures int) time.Duration {
+  delay := RetryDelay
+  for i := 1; i < failures && delay < MaxRetryDelay; i++ {
+    delay *= 2
+  }
+  if delay > MaxRetryDelay {
+    delay = MaxRetryDelay
+  }
+  return delay
+}
+
+/* Whether a failed download will be retried on its own */
+func (d *download) retrying() bool {
+  return d.failures < MaxAttempts
+}
+
+/* Records a download as failed, arranging for it to be tried again later if
+ * it hasn't failed too often. The client must be locked. */
+func (c *Client) fail(dl *download, reason error) {
+  dl.failures++
+  dl.reason = reason.Error()
+  c.failed = append(c.failed, dl)
+  dl.finish(reason)
+  if dl.retrying() {
+    c.retryIn(dl, retryDelay(dl.failures))
+  }
+}
+
+/* The client must be locked */
+func (c *Client) retryIn(dl *download, delay time.Duration) {
+  if dl.timer != nil {
+    dl.timer.Stop()
+  }
+  dl.retryAt = time.Now().Add(delay)
+  dl.timer = time.AfterFunc(delay, func() { c.requeue(dl) })
+}
+
+/* Moves a failed download back into the queue, if it's still failed */
+func (c *Client) requeue(dl *download) error {
+  c.Lock()
+  found := false
+  for i, f := range c.failed {
+    if f == dl {
+      c.failed = append(c.failed[:i], c.failed[i+1:]...)
+      found = true
+      break
+    }
+  }
+  if dl.timer != nil {
+    dl.timer.Stop()
+    dl.timer = nil
+  }
+  dl.retryAt = time.Time{}
+  c.Unlock()
+  if !found { return nil }
+  c.log("Retrying download of: " + dl.file)
+  err := c.download(dl)
+  c.saveQueue()
+  return err
+}
+
+/* Queues a failed download up again, giving it all of its attempts back */
+func (c *Client) RetryDownload(id uint64) error {
+  c.Lock()
+  var dl *download
+  for _, f := range c.failed {
+    if f.id == id {
+      dl = f
+    }
+  }
+  if dl != nil {
+    dl.failures = 0
+  }
+  c.Unlock()
+  if dl == nil { return UnknownDownload }
+  return c.requeue(dl)
+}
+
+/* Retries every failed download, returning how many there were */
+func (c *Client) RetryAll() (int, error) {
+  return c.retryWhere(func(dl *download) bool { return true }, true)
+}
+
+/* Retries everything from a nick which is still waiting to be retried */
+func (c *Client) retryNick(nick string) {
+  n, err := c.retryWhere(func(dl *download) bool {
+    return dl.nick == nick && dl.retrying()
+  }, false)
+  if err != nil {
+    c.log("couldn't retry downloads from " + nick + ": " + err.Error())
+  } else if n > 0 {
+    c.log(nick + " is back, retrying their downloads")
+  }
+}
+
+func (c *Client) retryWhere(which func(*download) bool,
+                            reset bool) (int, error) {
+  c.Lock()
+  dls := make([]*download, 0)
+  for _, dl := range c.failed {
+    if which(dl) {
+      dls = append(dls, dl)
+      if reset {
+        dl.failures = 0
+      }
+    }
+  }
+  c.Unlock()
+  for _, dl := range dls {
+    if err := c.requeue(dl); err != nil { return 0, err }
+  }
+  return len(dls), nil
+}
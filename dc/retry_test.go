@@ -0,0 +1,148 @@
+package dc
+
+import "os"
+import "testing"
+import "time"
+
+func Test_RetryDelay(t *testing.T) {
+  if retryDelay(1) != RetryDelay { t.Error(retryDelay(1)) }
+  if retryDelay(2) != 2 * RetryDelay { t.Error(retryDelay(2)) }
+  if retryDelay(3) != 4 * RetryDelay { t.Error(retryDelay(3)) }
+  if retryDelay(100) != MaxRetryDelay { t.Error(retryDelay(100)) }
+}
+
+/* Waits for a download to show up in a peer's queue again */
+func waitQueued(t *testing.T, c *Client, dl *download) {
+  for i := 0; i < 200; i++ {
+    c.Lock()
+    p := c.peers[dl.nick]
+    queued := false
+    if p != nil {
+      for _, d := range p.dls {
+        queued = queued || d == dl
+      }
+    }
+    c.Unlock()
+    if queued { return }
+    time.Sleep(5 * time.Millisecond)
+  }
+  t.Fatal("never queued again: ", dl.file)
+}
+
+func Test_RetryFailed(t *testing.T) {
+  defer func(delay time.Duration) { RetryDelay = delay }(RetryDelay)
+  RetryDelay = 10 * time.Millisecond
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  c := NewClient()
+  c.Quiet = true
+  c.CacheDir = wd
+
+  dl := NewDownload("foo", "a")
+  c.Lock()
+  c.identify(dl)
+  c.fail(dl, PeerGone)
+  queue := c.failed
+  c.Unlock()
+  if len(queue) != 1 || dl.failures != 1 || dl.reason != PeerGone.Error() {
+    t.Fatal(queue, dl.failures, dl.reason)
+  }
+  waitQueued(t, c, dl)
+  if len(c.Queue()) != 1 || c.Queue()[0].State != Waiting {
+    t.Error(c.Queue())
+  }
+
+  /* eventually it's given up on */
+  dl.failures = MaxAttempts - 1
+  c.Lock()
+  c.peers["foo"].remove(dl)
+  c.fail(dl, CorruptData)
+  c.Unlock()
+  if dl.timer != nil || dl.retrying() { t.Error(dl.failures) }
+  queue2 := c.Queue()
+  if len(queue2) != 1 || queue2[0].State != Failed ||
+     queue2[0].Reason != CorruptData.Error() || !queue2[0].RetryAt.IsZero() {
+    t.Fatal(queue2)
+  }
+
+  /* and failures are remembered */
+  c.saveQueue()
+  c2 := NewClient()
+  c2.Quiet = true
+  c2.CacheDir = wd
+  if err := c2.LoadQueue(); err != nil { t.Fatal(err) }
+  if len(c2.failed) != 1 { t.Fatal(c2.failed) }
+  loaded := c2.failed[0]
+  if loaded.failures != MaxAttempts || loaded.reason != CorruptData.Error() {
+    t.Error(loaded.failures, loaded.reason)
+  }
+
+  /* retrying by hand starts over */
+  if err := c2.RetryDownload(loaded.id); err != nil { t.Fatal(err) }
+  if loaded.failures != 0 || len(c2.failed) != 0 { t.Error(loaded.failures) }
+  waitQueued(t, c2, loaded)
+  if err := c2.RetryDownload(loaded.id); err != UnknownDownload { t.Error(err) }
+}
+
+/* Nicks coming back to the hub have their downloads retried right away */
+func Test_RetryOnRejoin(t *testing.T) {
+  c := NewClient()
+  c.Quiet = true
+  a, b := NewDownload("foo", "a"), NewDownload("bar", "b")
+  c.Lock()
+  for _, dl := range []*download{a, b} {
+    c.identify(dl)
+    c.fail(dl, PeerGone)
+  }
+  c.Unlock()
+
+  var m method
+  parseMethod(&m, []byte("$Hello foo"))
+  c.hubExec(&m)
+  waitQueued(t, c, a)
+  c.Lock()
+  if len(c.failed) != 1 || c.failed[0] != b { t.Error(c.failed) }
+  c.Unlock()
+
+  parseMethod(&m, []byte("$MyINFO $ALL bar desc$ $1\x01$$0$"))
+  c.hubExec(&m)
+  waitQueued(t, c, b)
+
+  /* and nothing is left waiting */
+  n, err := c.RetryAll()
+  if err != nil || n != 0 { t.Error(n, err) }
+  if a.timer != nil || b.timer != nil { t.Error() }
+}
+
+/* Stopping the client keeps everything queued for next time */
+func Test_StopKeepsQueue(t *testing.T) {
+  var m method
+  c, in, out, _, _out := setupPeer(t)
+  defer os.RemoveAll(c.DownloadRoot)
+  defer _out.Close()
+  handshake(t, in, out, "ADCGet")
+
+  a := NewDownloadFile("bar", "/a", &File{Size: 4})
+  b := NewDownloadFile("bar", "/b", &File{Size: 4})
+  go c.download(a)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file a 0 4" { t.Fatal(string(m.data)) }
+  if err := c.download(b); err != nil { t.Fatal(err) }
+  xsend(t, out, "$ADCSND file a 0 4|ab")
+  c.Stop()
+
+  c.Lock()
+  if len(c.failed) != 0 || a.failures != 0 || b.failures != 0 {
+    t.Error(c.failed)
+  }
+  c.Unlock()
+  c2 := NewClient()
+  c2.Quiet = true
+  c2.CacheDir = c.CacheDir
+  if err := c2.LoadQueue(); err != nil { t.Fatal(err) }
+  queue := c2.Queue()
+  if len(queue) != 2 || queue[0].State == Failed ||
+     queue[1].State == Failed {
+    t.Error(queue)
+  }
+}
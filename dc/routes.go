@@ -0,0 +1,202 @@
+package dc
+
+import "encoding/gob"
+import "errors"
+import "os"
+import "path"
+import "path/filepath"
+import "regexp"
+import "strings"
+
+/* Routes send downloads somewhere other than the download root. A route
+ * applies to a file when all of its conditions match, and the first route
+ * which applies, in the order they were added, decides where the file goes.
+ * Sizes of 0 mean that there's no bound, and extensions are compared like
+ * those of share filters. */
+type Route struct {
+  Name     string
+  Nick     string   /* only files from this nick */
+  Exts     []string /* only files with these extensions */
+  MinSize  ByteSize
+  MaxSize  ByteSize
+  Root     string   /* where to download to, the download root if empty */
+  Template string   /* path underneath the root, the client's if empty */
+}
+
+/* Templates lay out downloads underneath their root. The path of a download is
+ * relative to the directory which was asked for, while the remote directory is
+ * where the file is in the peer's listing. */
+const DefaultTemplate = "{path}"
+
+var templateVars = map[string]bool{
+  "nick": true, "path": true, "dir": true, "remote_dir": true,
+  "name": true, "ext": true,
+}
+var templatePattern = regexp.MustCompile("{([^{}]*)}")
+
+const routesVersion = 1
+const routesName = "routes.db"
+
+type routeFile struct {
+  Version  int
+  Template string
+  Routes   []Route
+}
+
+func CheckTemplate(template string) error {
+  for _, m := range templatePattern.FindAllStringSubmatch(template, -1) {
+    if !templateVars[m[1]] {
+      return errors.New("unknown template variable: " + m[0])
+    }
+  }
+  return nil
+}
+
+func (r *Route) matches(nick string, f *File) bool {
+  if r.Nick != "" && r.Nick != nick { return false }
+  if r.MinSize > 0 && f.Size < r.MinSize { return false }
+  if r.MaxSize > 0 && f.Size > r.MaxSize { return false }
+  if len(r.Exts) > 0 {
+    return containsExt(r.Exts, normalizeExt(path.Ext(f.Name)))
+  }
+  return true
+}
+
+/* Fills in a template for a file at the remote path, which was downloaded as
+ * part of asking for the relative path. Names are made safe on their own so
+ * that they can't add directories, the paths are made safe with the rest of
+ * the destination. */
+func expand(template, nick, remote, rel string) string {
+  if template == "" {
+    template = DefaultTemplate
+  }
+  remoteDir, name := path.Split(remote)
+  dir, _ := path.Split(rel)
+  ext := path.Ext(name)
+  return templatePattern.ReplaceAllStringFunc(template, func(v string) string {
+    switch v[1:len(v)-1] {
+    case "nick":       return SafeName(nick)
+    case "path":       return rel
+    case "dir":        return dir
+    case "remote_dir": return remoteDir
+    case "name":       return name
+    case "ext":        return strings.TrimPrefix(ext, ".")
+    }
+    return v
+  })
+}
+
+/* Decides where a file being downloaded goes */
+func (c *Client) route(dl *download, f *File, remote, rel string) {
+  c.Lock()
+  template := c.Template
+  for i := range c.routes {
+    r := &c.routes[i]
+    if !r.matches(dl.nick, f) { continue }
+    dl.root = r.Root
+    if r.Template != "" {
+      template = r.Template
+    }
+    break
+  }
+  c.Unlock()
+  dl.reldst = expand(template, dl.nick, remote, rel)
+}
+
+/* Adds a route, replacing any other of the same name */
+func (c *Client) SetRoute(r Route) error {
+  if r.Name == "" { return errors.New("routes need a name") }
+  if err := CheckTemplate(r.Template); err != nil { return err }
+  if r.Root != "" {
+    root, err := filepath.Abs(r.Root)
+    if err != nil { return err }
+    r.Root = root
+  }
+  c.Lock()
+  found := false
+  for i := range c.routes {
+    if c.routes[i].Name == r.Name {
+      c.routes[i] = r
+      found = true
+    }
+  }
+  if !found {
+    c.routes = append(c.routes, r)
+  }
+  c.Unlock()
+  return c.saveRoutes()
+}
+
+func (c *Client) RemoveRoute(name string) error {
+  c.Lock()
+  routes := c.routes[:0]
+  for _, r := range c.routes {
+    if r.Name != name {
+      routes = append(routes, r)
+    }
+  }
+  found := len(routes) != len(c.routes)
+  c.routes = routes
+  c.Unlock()
+  if !found { return errors.New("no route named " + name) }
+  return c.saveRoutes()
+}
+
+func (c *Client) Routes() []Route {
+  c.Lock()
+  defer c.Unlock()
+  return append([]Route{}, c.routes...)
+}
+
+/* Sets the template for downloads which no route gives one to */
+func (c *Client) SetTemplate(template string) error {
+  if err := CheckTemplate(template); err != nil { return err }
+  c.Lock()
+  c.Template = template
+  c.Unlock()
+  return c.saveRoutes()
+}
+
+/* Routes are kept in the cache directory along with the queue */
+func (c *Client) saveRoutes() error {
+  if c.CacheDir == "" { return nil }
+  c.Lock()
+  contents := routeFile{Version:  routesVersion,
+                        Template: c.Template,
+                        Routes:   append([]Route{}, c.routes...)}
+  c.Unlock()
+
+  err := os.MkdirAll(c.CacheDir, os.FileMode(0755))
+  if err != nil { return err }
+  dst := filepath.Join(c.CacheDir, routesName)
+  file, err := os.Create(dst + ".tmp")
+  if err != nil { return err }
+  err = gob.NewEncoder(file).Encode(&contents)
+  if err2 := file.Close(); err == nil {
+    err = err2
+  }
+  if err == nil {
+    err = os.Rename(file.Name(), dst)
+  }
+  if err != nil {
+    os.Remove(file.Name())
+  }
+  return err
+}
+
+func (c *Client) LoadRoutes() error {
+  file, err := os.Open(filepath.Join(c.CacheDir, routesName))
+  if os.IsNotExist(err) { return nil }
+  if err != nil { return err }
+  defer file.Close()
+
+  var contents routeFile
+  err = gob.NewDecoder(file).Decode(&contents)
+  if err != nil { return err }
+  if contents.Version != routesVersion { return nil }
+  c.Lock()
+  c.Template = contents.Template
+  c.routes = contents.Routes
+  c.Unlock()
+  return nil
+}
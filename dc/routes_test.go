@@ -0,0 +1,87 @@
+package dc
+
+import "os"
+import "testing"
+
+func Test_Templates(t *testing.T) {
+  cases := []struct{ template, want string }{
+    {"",                             "b/c.mkv"},
+    {"{path}",                       "b/c.mkv"},
+    {"{nick}/{remote_dir}/{name}",   "some_nick//a/b//c.mkv"},
+    {"{ext}/{dir}{name}",            "mkv/b/c.mkv"},
+    {"{nick}-{name}",                "some_nick-c.mkv"},
+  }
+  for _, c := range cases {
+    if err := CheckTemplate(c.template); err != nil { t.Error(err) }
+    got := expand(c.template, "some/nick", "/a/b/c.mkv", "b/c.mkv")
+    if got != c.want { t.Error(c.template, got) }
+  }
+  if CheckTemplate("{nick}/{wat}") == nil { t.Error() }
+}
+
+func Test_Routes(t *testing.T) {
+  c := NewClient()
+  c.CacheDir = tmpdir(t)
+  defer os.RemoveAll(c.CacheDir)
+  c.Template = "{nick}/{path}"
+
+  err := c.SetRoute(Route{Name: "video", Exts: []string{"mkv", "AVI"},
+                          Root: "/media/video", Template: "{name}"})
+  if err != nil { t.Fatal(err) }
+  err = c.SetRoute(Route{Name: "big", MinSize: 100, Root: "/big"})
+  if err != nil { t.Fatal(err) }
+  err = c.SetRoute(Route{Name: "bar", Nick: "bar", Root: "/bar"})
+  if err != nil { t.Fatal(err) }
+  if c.SetRoute(Route{Name: "x", Template: "{wat}"}) == nil { t.Error() }
+
+  route := func(nick, remote string, size ByteSize) *download {
+    f := &File{Name: remote[1:], Size: size}
+    dl := NewDownloadFile(nick, remote, f)
+    c.route(dl, f, remote, remote[1:])
+    return dl
+  }
+  dl := route("foo", "/dir/a.avi", 1000)
+  if dl.root != "/media/video" || dl.reldst != "a.avi" { t.Error(dl) }
+  dl = route("foo", "/dir/a.txt", 1000)
+  if dl.root != "/big" || dl.reldst != "foo/dir/a.txt" { t.Error(dl) }
+  dl = route("bar", "/dir/a.txt", 10)
+  if dl.root != "/bar" || dl.reldst != "bar/dir/a.txt" { t.Error(dl) }
+  dl = route("foo", "/dir/a.txt", 10)
+  if dl.root != "" || dl.reldst != "foo/dir/a.txt" { t.Error(dl) }
+
+  /* routes replace those of the same name, and come back after a restart */
+  err = c.SetRoute(Route{Name: "big", MinSize: 100, Root: "/huge"})
+  if err != nil { t.Fatal(err) }
+  err = c.RemoveRoute("bar")
+  if err != nil { t.Fatal(err) }
+  if c.RemoveRoute("bar") == nil { t.Error() }
+
+  c2 := NewClient()
+  c2.CacheDir = c.CacheDir
+  err = c2.LoadRoutes()
+  if err != nil { t.Fatal(err) }
+  routes := c2.Routes()
+  if len(routes) != 2 { t.Fatal(routes) }
+  if routes[0].Name != "video" || len(routes[0].Exts) != 2 { t.Error(routes) }
+  if routes[1].Name != "big" || routes[1].Root != "/huge" { t.Error(routes) }
+  if c2.Template != "{nick}/{path}" { t.Error(c2.Template) }
+  if c2.SetTemplate("{wat}") == nil { t.Error() }
+  err = c2.SetTemplate("{ext}/{name}")
+  if err != nil { t.Fatal(err) }
+  c3 := NewClient()
+  c3.CacheDir = c.CacheDir
+  err = c3.LoadRoutes()
+  if err != nil { t.Fatal(err) }
+  if c3.Template != "{ext}/{name}" { t.Error(c3.Template) }
+}
+
+/* Routed downloads end up underneath their own root */
+func Test_RoutedDestination(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  dl := NewDownload("foo", "a/b")
+  dl.root = wd + "/routed"
+  dst, err := dl.destination(wd + "/downloads")
+  if err != nil { t.Fatal(err) }
+  if dst != wd + "/routed/a/b" { t.Error(dst) }
+}
@@ -0,0 +1,127 @@
+package dc
+
+import "errors"
+import "strings"
+
+/* Downloads are started in order of priority, whoever they're from. Among
+ * downloads of the same priority the peer which was least recently downloaded
+ * from goes first, and then whatever was queued first. Paused downloads stay
+ * queued but are never started. */
+type Priority int
+
+const (
+  PriorityPaused Priority = iota - 3
+  PriorityLowest
+  PriorityLow
+  PriorityNormal
+  PriorityHigh
+  PriorityHighest
+)
+
+var NothingQueued = errors.New("no matching downloads are queued")
+
+func ParsePriority(s string) (Priority, error) {
+  switch s {
+  case "paused":  return PriorityPaused, nil
+  case "lowest":  return PriorityLowest, nil
+  case "low":     return PriorityLow, nil
+  case "normal":  return PriorityNormal, nil
+  case "high":    return PriorityHigh, nil
+  case "highest": return PriorityHighest, nil
+  }
+  return PriorityNormal, errors.New("unknown priority: " + s)
+}
+
+func (p Priority) String() string {
+  switch p {
+  case PriorityPaused:  return "paused"
+  case PriorityLowest:  return "lowest"
+  case PriorityLow:     return "low"
+  case PriorityHigh:    return "high"
+  case PriorityHighest: return "highest"
+  }
+  return "normal"
+}
+
+/* Whether a should be started before b */
+func before(a *download, pa *peer, b *download, pb *peer) bool {
+  if a.priority != b.priority { return a.priority > b.priority }
+  if pa.turn != pb.turn { return pa.turn < pb.turn }
+  return a.id < b.id
+}
+
+/* Picks the next download to start out of those queued on idle peers, leaving
+ * out the peers given. Any idle peer can also pick up part of a download
+ * which is being fetched in segments. The client must be locked. */
+func (c *Client) next(skip map[*peer]bool) (*peer, *download) {
+  var best *download
+  var from *peer
+  for _, p := range c.peers {
+    if skip[p] { continue }
+    p.Lock()
+    if p.state == Idle {
+      for _, dl := range p.dls {
+        if dl.priority == PriorityPaused { continue }
+        if best == nil || before(dl, p, best, from) {
+          best, from = dl, p
+        }
+      }
+      for _, dl := range c.segmented {
+        if dl.priority == PriorityPaused || !c.helps(p, dl) { continue }
+        if best == nil || before(dl, p, best, from) {
+          best, from = dl, p
+        }
+      }
+    }
+    p.Unlock()
+  }
+  return from, best
+}
+
+/* Takes a download out of the peer's queue */
+func (p *peer) remove(dl *download) {
+  for i, d := range p.dls {
+    if d == dl {
+      p.dls = append(p.dls[:i], p.dls[i+1:]...)
+      return
+    }
+  }
+}
+
+/* Changes the priority of everything from a nick at or underneath a path in
+ * their listing, whether it's queued or already downloading */
+func (c *Client) SetPriority(nick, pathname string,
+                             priority Priority) (int, error) {
+  pathname = strings.Trim(pathname, "/")
+  matches := func(dl *download) bool {
+    return !dl.fileList() && (pathname == "" || dl.file == pathname ||
+                              strings.HasPrefix(dl.file, pathname + "/"))
+  }
+  changed := 0
+  c.Lock()
+  if p := c.peers[nick]; p != nil {
+    p.Lock()
+    if p.dl != nil && p.dl.parent == nil && matches(p.dl) {
+      p.dl.priority = priority
+      changed++
+    }
+    for _, dl := range p.dls {
+      if matches(dl) {
+        dl.priority = priority
+        changed++
+      }
+    }
+    p.Unlock()
+  }
+  for _, dl := range c.segmented {
+    if dl.nick == nick && matches(dl) {
+      dl.priority = priority
+      changed++
+    }
+  }
+  c.Unlock()
+  if changed == 0 { return 0, NothingQueued }
+
+  c.saveQueue()
+  return changed, c.initiateDownload()
+}
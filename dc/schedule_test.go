@@ -0,0 +1,99 @@
+package dc
+
+import "os"
+import "testing"
+
+func Test_Priorities(t *testing.T) {
+  for _, name := range []string{"paused", "lowest", "low", "normal", "high",
+                                "highest"} {
+    p, err := ParsePriority(name)
+    if err != nil { t.Error(err) }
+    if p.String() != name { t.Error(p) }
+  }
+  if _, err := ParsePriority("urgent"); err == nil { t.Error() }
+  var zero Priority
+  if zero != PriorityNormal { t.Error(zero) }
+}
+
+/* The highest priority goes first, and peers take turns among equals */
+func Test_Scheduling(t *testing.T) {
+  c := NewClient()
+  c.Quiet = true
+  queue := func(nick, file string, priority Priority) *download {
+    dl := NewDownload(nick, file)
+    dl.priority = priority
+    if err := c.download(dl); err != nil { t.Fatal(err) }
+    c.peers[nick].state = Idle
+    return dl
+  }
+  a1 := queue("a", "1", PriorityNormal)
+  a2 := queue("a", "2", PriorityNormal)
+  b1 := queue("b", "1", PriorityNormal)
+  b2 := queue("b", "2", PriorityHigh)
+  queue("c", "1", PriorityPaused)
+  a, b := c.peers["a"], c.peers["b"]
+
+  start := func(want *download) {
+    p, dl := c.next(nil)
+    if dl != want { t.Fatal(dl, want) }
+    p.remove(dl)
+    c.turns++
+    p.turn = c.turns
+  }
+  start(b2)
+  /* b was just downloaded from, so it's a's turn */
+  start(a1)
+  start(b1)
+  start(a2)
+  if _, dl := c.next(nil); dl != nil { t.Error(dl) }
+
+  /* peers which are busy or skipped are left out */
+  queue("a", "3", PriorityHigh)
+  queue("b", "3", PriorityLow)
+  a.state = Downloading
+  if _, dl := c.next(nil); dl == nil || dl.nick != "b" { t.Error(dl) }
+  a.state = Idle
+  if _, dl := c.next(map[*peer]bool{a: true}); dl.nick != "b" { t.Error(dl) }
+  if _, dl := c.next(nil); dl.nick != "a" { t.Error(dl) }
+  if b.turn == 0 { t.Error() }
+}
+
+func Test_SetPriority(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  c := NewClient()
+  c.Quiet = true
+  c.CacheDir = wd
+  for _, file := range []string{"dir/a", "dir/b", "dirt", "other"} {
+    if err := c.download(NewDownload("foo", file)); err != nil {
+      t.Fatal(err)
+    }
+  }
+  n, err := c.SetPriority("foo", "/dir", PriorityPaused)
+  if err != nil || n != 2 { t.Error(n, err) }
+  n, err = c.SetPriority("foo", "/other", PriorityHighest)
+  if err != nil || n != 1 { t.Error(n, err) }
+  if _, err := c.SetPriority("foo", "/nope", PriorityHigh); err != NothingQueued {
+    t.Error(err)
+  }
+  if _, err := c.SetPriority("bar", "/", PriorityHigh); err != NothingQueued {
+    t.Error(err)
+  }
+
+  /* priorities survive restarts */
+  c = NewClient()
+  c.CacheDir = wd
+  if err := c.LoadQueue(); err != nil { t.Fatal(err) }
+  priorities := make(map[string]Priority)
+  for _, dl := range c.peers["foo"].dls {
+    priorities[dl.file] = dl.priority
+  }
+  if priorities["dir/a"] != PriorityPaused ||
+     priorities["dir/b"] != PriorityPaused ||
+     priorities["dirt"] != PriorityNormal ||
+     priorities["other"] != PriorityHighest {
+    t.Error(priorities)
+  }
+  /* and so does the order things were queued in */
+  if c.peers["foo"].dls[0].file != "dir/a" { t.Error(c.peers["foo"].dls[0]) }
+}
@@ -0,0 +1,579 @@
+package dc
+
+import "errors"
+import "fmt"
+import "io"
+import "math"
+import "os"
+import "sort"
+import "strings"
+import "sync/atomic"
+import "time"
+
+/* Files with a TTH can be fetched from everyone known to be sharing them at
+ * once. When such a download is started it's split up, and each of its
+ * sources which is free is handed the next segment of the file. Once there's
+ * nothing left to hand out, a source which is free takes over the back half
+ * of the slowest segment, provided that segment's source is slower than it,
+ * and the slow source is hung up on when it gets to where it was cut off.
+ * Segments are written into their place in the one temporary file, and the
+ * whole file is checked against its TTH once every part of it is in.
+ *
+ * Other sources come from the file lists we have, or from whoever else knows
+ * about them. */
+
+/* How much of a file a source is handed at once */
+var SegmentSize int64 = 4 << 20
+
+/* Segments aren't split up any smaller than this */
+var MinSegment int64 = 256 << 10
+
+var Stolen = errors.New("rest of the segment was taken over")
+
+/* Someone else sharing a file which is being downloaded */
+type Source struct {
+  Nick string
+  File string
+}
+
+type region struct {
+  start int64
+  end   int64
+}
+
+/* A part of a file being fetched by one of its sources */
+type segment struct {
+  nick    string
+  start   int64
+  end     int64 /* accessed atomically, moved back when it's taken over */
+  pos     int64 /* accessed atomically, how far it's been written */
+  started time.Time
+}
+
+/* What's left of a download which has been split up, only touched with the
+ * client locked */
+type parts struct {
+  free    []region /* nobody's fetching these yet */
+  active  []*segment
+  rates   map[string]float64 /* of each nick's last segment */
+  corrupt map[string]int     /* segments with corrupt blocks from each nick */
+}
+
+/* Whether a nick shares the file being downloaded */
+func (d *download) has(nick string) bool {
+  _, ok := d.sourceFile(nick)
+  return ok
+}
+
+/* The name a nick shares the file being downloaded under */
+func (d *download) sourceFile(nick string) (string, bool) {
+  if nick == d.nick { return d.file, true }
+  for _, s := range d.sources {
+    if s.Nick == nick { return s.File, true }
+  }
+  return "", false
+}
+
+/* Segments are kept to whole blocks when the leaves are known, so that each
+ * can be checked against them */
+func (d *download) grain() int64 {
+  if d.block > 0 { return d.block }
+  return 1
+}
+
+/* Whether a download should be split up to start it with a peer */
+func (d *download) segmentable(p *peer) bool {
+  if !p.implements("ADCGet") { return false }
+  return d.parts != nil || (d.tth != "" && !d.fileList() &&
+                            d.size > SegmentSize && len(d.sources) > 0)
+}
+
+/* Goes back to fetching a download from one place at a time, picking up from
+ * the first gap in what's been fetched */
+func (d *download) flatten() {
+  if d.parts == nil { return }
+  total, done := d.total(), d.resumable()
+  d.offset, d.size = done, total - done
+  d.parts = nil
+}
+
+/* How much of a download has been fetched */
+func (d *download) fetched() int64 {
+  if d.parts != nil { return d.total() - d.parts.left() }
+  return d.offset
+}
+
+/* How much of a download could be picked up from after a restart, which for
+ * one which has been split up is only up to its first gap */
+func (d *download) resumable() int64 {
+  if d.parts != nil { return d.parts.prefix(d.total()) }
+  return d.offset
+}
+
+/* Adds everyone else whose file list has the same file as sources of a
+ * download. The client must be locked. */
+func (c *Client) findSources(dl *download) {
+  if dl.tth == "" || dl.fileList() { return }
+  found := errors.New("found")
+  for nick, list := range c.lists {
+    if dl.has(nick) { continue }
+    list.EachFile("/", func(f *File, pathname string) error {
+      if f.TTH != dl.tth || int64(f.Size) != dl.total() { return nil }
+      dl.sources = append(dl.sources, Source{Nick: nick, File: pathname[1:]})
+      return found
+    })
+  }
+}
+
+/* Adds another nick known to share a file which is queued, such as from a
+ * search, who will be asked for part of it once it's started */
+func (c *Client) AddSource(id uint64, nick, pathname string) error {
+  c.Lock()
+  _, dl := c.find(id)
+  if dl != nil && !dl.has(nick) {
+    file := strings.TrimPrefix(pathname, "/")
+    dl.sources = append(dl.sources, Source{Nick: nick, File: file})
+  }
+  segmented := dl != nil && c.segmenting(dl)
+  c.Unlock()
+  if dl == nil { return UnknownDownload }
+
+  if segmented {
+    c.reach(nick)
+  }
+  c.saveQueue()
+  return c.initiateDownload()
+}
+
+/* Whether a download is being fetched in segments. The client must be
+ * locked. */
+func (c *Client) segmenting(dl *download) bool {
+  for _, d := range c.segmented {
+    if d == dl { return true }
+  }
+  return false
+}
+
+/* Starts fetching a download in segments, keeping whatever was fetched
+ * already, and returns the nicks to ask for connections once the client is
+ * unlocked. The client must be locked. */
+func (c *Client) segment(dl *download) []string {
+  if dl.parts == nil {
+    dl.parts = &parts{free: []region{{dl.offset, dl.total()}},
+                      rates: make(map[string]float64),
+                      corrupt: make(map[string]int)}
+  }
+  c.segmented = append(c.segmented, dl)
+  nicks := []string{dl.nick}
+  for _, s := range dl.sources {
+    nicks = append(nicks, s.Nick)
+  }
+  return nicks
+}
+
+/* Stops fetching a download in segments, hanging up on whoever is still
+ * fetching one if there's a reason to. Returns whether it was being fetched
+ * in segments. The client must be locked. */
+func (c *Client) unsegment(dl *download, reason error) bool {
+  for i, d := range c.segmented {
+    if d != dl { continue }
+    c.segmented = append(c.segmented[:i], c.segmented[i+1:]...)
+    if reason != nil {
+      for _, p := range c.peers {
+        p.Lock()
+        if p.dl != nil && p.dl.parent == dl {
+          p.drop(p.dl, reason)
+        }
+        p.Unlock()
+      }
+    }
+    return true
+  }
+  return false
+}
+
+/* Whether a peer has anything to do for a download being fetched in
+ * segments. Both the client and peer must be locked. */
+func (c *Client) helps(p *peer, dl *download) bool {
+  if !dl.has(p.nick) || !p.implements("ADCGet") { return false }
+  if dl.parts.corrupt[p.nick] >= CorruptLimit { return false }
+  _, ok := dl.parts.pick(p.nick, time.Now())
+  return ok
+}
+
+/* Starts a peer on the next segment of a download, if there's anything for
+ * it to do. The client must be locked. */
+func (c *Client) startSegment(p *peer, dl *download) (bool, error) {
+  p.Lock()
+  helps := c.helps(p, dl)
+  p.Unlock()
+  if !helps { return false, nil }
+
+  file, _ := dl.sourceFile(p.nick)
+  seg := dl.parts.next(p.nick, dl.grain(), time.Now())
+  if seg == nil { return false, nil }
+  part := &download{nick: p.nick, file: file, tth: dl.tth,
+                    offset: seg.start, size: seg.end - seg.start,
+                    dst: dl.dst, conflict: dl.conflict, priority: dl.priority,
+                    leaves: dl.leaves, block: dl.block,
+                    unverified: dl.unverified, parent: dl, seg: seg}
+  out, err := part.open(c.DownloadRoot)
+  if err != nil {
+    dl.parts.finish(seg, nil, time.Now())
+    return false, err
+  }
+  if p.download(out, part) != nil {
+    out.Close()
+    dl.parts.finish(seg, nil, time.Now())
+    return false, nil
+  }
+  return true, nil
+}
+
+/* Leaves of the file's tree fetched for a segment are kept for the rest */
+func (c *Client) shareLeaves(part *download) {
+  c.Lock()
+  if part.leaves != nil && part.parent.leaves == nil {
+    part.parent.leaves = part.leaves
+    part.parent.block = part.block
+  }
+  c.Unlock()
+}
+
+/* Wraps up the segment a peer was fetching once it's got all it's going to.
+ * Corrupt blocks and anything it didn't get to are handed back to be fetched
+ * again, and if that was the last of the file then it's checked and moved
+ * into place. A source which was taken over from is hung up on, since the
+ * rest of what it's sending isn't wanted. */
+func (c *Client) endSegment(p *peer, stolen bool) error {
+  part := p.dl
+  dl := part.parent
+  var bad []region
+  if part.leaves != nil {
+    written := atomic.LoadInt64(&part.seg.pos) - part.seg.start
+    blocks, err := part.verify(p.file, part.seg.start, written)
+    if err != nil { return err }
+    for _, i := range blocks {
+      start, n := part.segment(i)
+      bad = append(bad, region{start, start + n})
+    }
+  }
+  p.file.Close()
+  p.file = nil
+
+  c.Lock()
+  c.finishSegment(part, bad)
+  ps := dl.parts
+  segmenting := c.segmenting(dl)
+  done := segmenting && ps.complete()
+  /* nobody's left who it could be fetched from */
+  hopeless := segmenting && !done && len(ps.active) == 0 && !ps.usable(dl)
+  if done || hopeless {
+    c.unsegment(dl, nil)
+  }
+  if done {
+    c.startVerify(dl)
+  }
+  if hopeless {
+    os.Remove(dl.temp())
+    dl.restart()
+    c.fail(dl, CorruptData)
+  } else if dl.purge && len(ps.active) == 0 {
+    os.Remove(dl.temp())
+  }
+  c.Unlock()
+
+  if stolen {
+    p.Lock()
+    p.drop(part, Stolen)
+    p.Unlock()
+  } else {
+    c.DL.release() /* if we fail with error, our slot is released elsewhere */
+    p.dl = nil
+    p.state = Idle
+  }
+  if !done {
+    c.saveQueue()
+  }
+  if stolen { return Stolen }
+  return c.initiateDownload()
+}
+
+/* Takes a segment out of those being fetched. The client must be locked. */
+func (c *Client) finishSegment(part *download, bad []region) {
+  ps := part.parent.parts
+  if !ps.finish(part.seg, bad, time.Now()) { return }
+  if len(bad) == 0 { return }
+  ps.corrupt[part.nick]++
+  c.log(fmt.Sprintf("%d corrupt blocks of %s from %s", len(bad),
+                    part.parent.file, part.nick))
+  if ps.corrupt[part.nick] >= CorruptLimit {
+    c.log("not fetching any more of " + part.parent.file + " from " +
+          part.nick + ": " + CorruptData.Error())
+  }
+}
+
+/* Hands back what's left of a segment whose source went away. Once the last
+ * segment stops, a canceled download has its partial file cleaned up if it's
+ * to go too. Otherwise the rest is left to whoever else could fetch parts of
+ * it, including a source which was only hung up on because it was taken over
+ * from. If nobody can, it's fetched from one source at a time again from
+ * whoever's around, failing like any other if there's nobody. Returns whether
+ * it should be queued up again, and the nicks to ask for connections once the
+ * client is unlocked. The client must be locked. */
+func (c *Client) segmentGone(part *download) (bool, []string) {
+  dl := part.parent
+  c.finishSegment(part, nil)
+  if len(dl.parts.active) > 0 { return false, nil }
+  if dl.purge {
+    os.Remove(dl.temp())
+  }
+  if part.stop != nil && part.stop != Stolen { return false, nil }
+  if !c.segmenting(dl) { return false, nil }
+  gone := part.nick
+  if part.stop == Stolen {
+    gone = ""
+  }
+  if nicks := c.helpers(dl, gone); len(nicks) > 0 { return false, nicks }
+  if c.regroup(dl, gone) || c.alternate(dl) { return true, nil }
+  c.fail(dl, PeerGone)
+  return false, nil
+}
+
+/* Which of a download's sources could be asked for parts of it: those who are
+ * around, haven't sent too much corrupt data, and either support ADCGet or
+ * haven't been connected to yet to find out. The one which is gone is left
+ * out. The client must be locked. */
+func (c *Client) helpers(dl *download, gone string) []string {
+  nicks := make([]string, 0)
+  check := func(nick string) {
+    if nick == gone || dl.parts.corrupt[nick] >= CorruptLimit { return }
+    if !c.reachable(nick) { return }
+    if p := c.peers[nick]; p != nil {
+      p.Lock()
+      unable := p.state >= Idle && !p.implements("ADCGet")
+      p.Unlock()
+      if unable { return }
+    }
+    nicks = append(nicks, nick)
+  }
+  check(dl.nick)
+  for _, s := range dl.sources {
+    check(s.Nick)
+  }
+  return nicks
+}
+
+/* Stops fetching a download in segments once nobody can be asked for parts of
+ * it, moving it over to whoever's around to fetch the rest from. Returns
+ * whether there was anyone. The client must be locked. */
+func (c *Client) regroup(dl *download, gone string) bool {
+  c.unsegment(dl, nil)
+  dl.flatten()
+  if dl.nick != gone && c.reachable(dl.nick) { return true }
+  return c.reroute(dl, gone)
+}
+
+/* Downloads being fetched in segments which are stuck because everyone around
+ * who has them can't fetch parts. The client must be locked. */
+func (c *Client) stranded() []*download {
+  dls := make([]*download, 0)
+  for _, dl := range c.segmented {
+    if len(dl.parts.active) > 0 || len(c.helpers(dl, "")) > 0 { continue }
+    if c.available(dl) {
+      dls = append(dls, dl)
+    }
+  }
+  return dls
+}
+
+/* Whether any of a download's sources could be downloaded from now. Other
+ * sources only help once it's been split up. The client must be locked. */
+func (c *Client) available(dl *download) bool {
+  if c.reachable(dl.nick) { return true }
+  if !c.segmenting(dl) { return false }
+  for _, s := range dl.sources {
+    if c.reachable(s.Nick) { return true }
+  }
+  return false
+}
+
+/* Whether anyone hasn't sent too much corrupt data to be asked for more */
+func (ps *parts) usable(dl *download) bool {
+  if ps.corrupt[dl.nick] < CorruptLimit { return true }
+  for _, s := range dl.sources {
+    if ps.corrupt[s.Nick] < CorruptLimit { return true }
+  }
+  return false
+}
+
+/* Finds what a source could be handed next: the start of what's free, or
+ * failing that the slowest segment worth splitting if it's slower than the
+ * source has been. Returns false if there's nothing. */
+func (ps *parts) pick(nick string, now time.Time) (*segment, bool) {
+  if len(ps.free) > 0 { return nil, true }
+  var slowest *segment
+  worst := 0.0
+  for _, s := range ps.active {
+    pos, end := atomic.LoadInt64(&s.pos), atomic.LoadInt64(&s.end)
+    if end - pos < 2 * MinSegment { continue }
+    rate := s.rate(pos, now)
+    eta := math.Inf(1)
+    if rate > 0 {
+      eta = float64(end - pos) / rate
+    }
+    if slowest == nil || eta > worst {
+      slowest, worst = s, eta
+    }
+  }
+  if slowest == nil { return nil, false }
+  known := ps.rates[nick]
+  if known > 0 && known <= slowest.rate(atomic.LoadInt64(&slowest.pos), now) {
+    return nil, false
+  }
+  return slowest, true
+}
+
+/* Bytes per second a segment has been coming in at */
+func (s *segment) rate(pos int64, now time.Time) float64 {
+  elapsed := now.Sub(s.started).Seconds()
+  if elapsed <= 0 { return 0 }
+  return float64(pos - s.start) / elapsed
+}
+
+/* Hands a source the next segment to fetch, kept to multiples of grain, or
+ * nil if there's nothing to hand out */
+func (ps *parts) next(nick string, grain int64, now time.Time) *segment {
+  victim, ok := ps.pick(nick, now)
+  if !ok { return nil }
+  var seg *segment
+  if victim == nil {
+    f := &ps.free[0]
+    end := f.start + SegmentSize
+    end -= end % grain
+    if end <= f.start {
+      end = f.start + grain
+    }
+    /* leftovers too small to be worth their own segment go along too */
+    if f.end - end < MinSegment {
+      end = f.end
+    }
+    seg = &segment{start: f.start, end: end, pos: f.start}
+    f.start = end
+    if f.start >= f.end {
+      ps.free = ps.free[1:]
+    }
+  } else {
+    pos, end := atomic.LoadInt64(&victim.pos), atomic.LoadInt64(&victim.end)
+    cut := pos + (end - pos) / 2
+    cut -= cut % grain
+    if cut <= pos { return nil }
+    atomic.StoreInt64(&victim.end, cut)
+    seg = &segment{start: cut, end: end, pos: cut}
+  }
+  seg.nick = nick
+  seg.started = now
+  ps.active = append(ps.active, seg)
+  return seg
+}
+
+/* Takes a segment out of those being fetched, handing back whatever it
+ * didn't get to along with any corrupt blocks. Returns whether it was still
+ * being fetched. */
+func (ps *parts) finish(seg *segment, bad []region, now time.Time) bool {
+  found := false
+  for i, s := range ps.active {
+    if s == seg {
+      ps.active = append(ps.active[:i], ps.active[i+1:]...)
+      found = true
+      break
+    }
+  }
+  if !found { return false }
+  pos, end := atomic.LoadInt64(&seg.pos), atomic.LoadInt64(&seg.end)
+  if pos > end {
+    pos = end
+  }
+  if pos < end {
+    ps.release(region{pos, end})
+  }
+  for _, b := range bad {
+    ps.release(b)
+  }
+  if rate := seg.rate(pos, now); rate > 0 {
+    ps.rates[seg.nick] = rate
+  }
+  return true
+}
+
+/* Puts part of the file back to be fetched again */
+func (ps *parts) release(s region) {
+  free := append(ps.free, s)
+  sort.Slice(free, func(i, j int) bool { return free[i].start < free[j].start })
+  ps.free = free[:1]
+  for _, s := range free[1:] {
+    last := &ps.free[len(ps.free) - 1]
+    if s.start > last.end {
+      ps.free = append(ps.free, s)
+    } else if s.end > last.end {
+      last.end = s.end
+    }
+  }
+}
+
+func (ps *parts) complete() bool {
+  return len(ps.free) == 0 && len(ps.active) == 0
+}
+
+/* How much is still to be fetched */
+func (ps *parts) left() int64 {
+  left := int64(0)
+  for _, f := range ps.free {
+    left += f.end - f.start
+  }
+  for _, s := range ps.active {
+    if n := atomic.LoadInt64(&s.end) - atomic.LoadInt64(&s.pos); n > 0 {
+      left += n
+    }
+  }
+  return left
+}
+
+/* Where the first part which hasn't been fetched yet starts */
+func (ps *parts) prefix(total int64) int64 {
+  for _, f := range ps.free {
+    if f.start < total {
+      total = f.start
+    }
+  }
+  for _, s := range ps.active {
+    if s.start < total {
+      total = s.start
+    }
+  }
+  return total
+}
+
+/* Writes a segment into its place in the file, stopping where it ends even
+ * if that's moved back part way through */
+type segmentWriter struct {
+  w   io.Writer
+  seg *segment
+}
+
+func (s *segmentWriter) Write(b []byte) (int, error) {
+  left := atomic.LoadInt64(&s.seg.end) - atomic.LoadInt64(&s.seg.pos)
+  stolen := int64(len(b)) > left
+  if stolen {
+    if left < 0 {
+      left = 0
+    }
+    b = b[:left]
+  }
+  n, err := s.w.Write(b)
+  atomic.AddInt64(&s.seg.pos, int64(n))
+  if err == nil && stolen {
+    err = Stolen
+  }
+  return n, err
+}
@@ -0,0 +1,309 @@
+package dc
+
+import "bufio"
+import "bytes"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+import "github.com/alexcrichton/fargo/dc/tth"
+
+func withSegments(size, min int64) func() {
+  oldSize, oldMin := SegmentSize, MinSegment
+  SegmentSize, MinSegment = size, min
+  return func() { SegmentSize, MinSegment = oldSize, oldMin }
+}
+
+func Test_SegmentParts(t *testing.T) {
+  defer withSegments(8, 3)()
+  now := time.Now()
+  later := func(s int) time.Time {
+    return now.Add(time.Duration(s) * time.Second)
+  }
+  ps := &parts{free: []region{{0, 20}}, rates: make(map[string]float64),
+               corrupt: make(map[string]int)}
+
+  /* the file is handed out in order, the last bit going along with the
+   * segment before it if it's too small */
+  s1 := ps.next("a", 1, now)
+  s2 := ps.next("b", 1, now)
+  s3 := ps.next("c", 1, now)
+  if s1.start != 0 || s1.end != 8 || s2.start != 8 || s2.end != 16 ||
+     s3.start != 16 || s3.end != 20 {
+    t.Fatal(s1, s2, s3)
+  }
+  if ps.left() != 20 || ps.prefix(20) != 0 || ps.complete() { t.Error() }
+
+  /* corrupt blocks are handed out again */
+  s2.pos = 16
+  if !ps.finish(s2, nil, later(1)) || ps.rates["b"] != 8 { t.Error(ps.rates) }
+  s3.pos = 20
+  ps.finish(s3, []region{{17, 18}}, later(1))
+  if len(ps.free) != 1 || ps.free[0] != (region{17, 18}) { t.Fatal(ps.free) }
+  s4 := ps.next("b", 1, later(1))
+  if s4.start != 17 || s4.end != 18 { t.Error(s4) }
+  s4.pos = 18
+  ps.finish(s4, nil, later(2))
+
+  /* with nothing left, the back half of the slowest segment is taken */
+  s5 := ps.next("b", 1, later(2))
+  if s5 == nil || s5.start != 4 || s5.end != 8 || s1.end != 4 { t.Fatal(s5) }
+  if ps.next("c", 1, later(2)) != nil { t.Error("split too small") }
+
+  /* what a segment didn't get to is handed back */
+  s1.pos = 2
+  ps.finish(s1, nil, later(3))
+  if len(ps.free) != 1 || ps.free[0] != (region{2, 4}) { t.Fatal(ps.free) }
+  if ps.prefix(20) != 2 || ps.left() != 6 { t.Error(ps.prefix(20), ps.left()) }
+  if ps.finish(s1, nil, later(3)) { t.Error("finished twice") }
+
+  /* only sources faster than the slow one take over from it */
+  ps = &parts{rates: map[string]float64{"slow": 10, "fast": 100},
+              active: []*segment{{start: 0, end: 100, pos: 50,
+                                  started: now}}}
+  if _, ok := ps.pick("slow", later(1)); ok { t.Error("slow took over") }
+  if _, ok := ps.pick("fast", later(1)); !ok { t.Error("fast didn't") }
+  seg := ps.next("new", 1, later(1))
+  if seg == nil || seg.start != 75 || ps.active[0].end != 75 { t.Error(seg) }
+}
+
+func Test_SegmentRelease(t *testing.T) {
+  ps := &parts{free: []region{{0, 2}}}
+  ps.release(region{5, 6})
+  ps.release(region{1, 4})
+  ps.release(region{4, 5})
+  if len(ps.free) != 1 || ps.free[0] != (region{0, 6}) { t.Error(ps.free) }
+}
+
+func Test_SegmentWriter(t *testing.T) {
+  var buf bytes.Buffer
+  seg := &segment{start: 0, end: 4}
+  w := &segmentWriter{w: &buf, seg: seg}
+  if n, err := w.Write([]byte("ab")); n != 2 || err != nil { t.Error(n, err) }
+  seg.end = 3
+  if n, err := w.Write([]byte("cd")); n != 1 || err != Stolen {
+    t.Error(n, err)
+  }
+  if n, err := w.Write([]byte("e")); n != 0 || err != Stolen { t.Error(n, err) }
+  if buf.String() != "abc" || seg.pos != 3 { t.Error(buf.String(), seg.pos) }
+}
+
+func Test_FindSources(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  c := NewClient()
+  c.Quiet = true
+  c.CacheDir = wd
+  list := &FileListing{}
+  list.Dirs = []Directory{{Name: "d", Files: []*File{
+    {Name: "x", Size: 10, TTH: "T"},
+  }}}
+  other := &FileListing{}
+  other.Files = []*File{{Name: "x", Size: 11, TTH: "T"}}
+  c.lists["bar"] = list
+  c.lists["baz"] = list
+  c.lists["quux"] = other
+
+  dl := NewDownloadFile("bar", "/d/x", &File{Size: 10, TTH: "T"})
+  c.findSources(dl)
+  if len(dl.sources) != 1 || dl.sources[0] != (Source{"baz", "d/x"}) {
+    t.Fatal(dl.sources)
+  }
+  c.findSources(dl)
+  if len(dl.sources) != 1 { t.Error(dl.sources) }
+
+  /* sources are remembered, and a file with gaps is only picked up from
+   * before the first of them */
+  if err := c.download(dl); err != nil { t.Fatal(err) }
+  if err := c.AddSource(dl.id, "other", "/y"); err != nil { t.Fatal(err) }
+  if err := c.AddSource(7, "other", "/y"); err != UnknownDownload {
+    t.Error(err)
+  }
+  dl.dst = filepath.Join(wd, "x")
+  err := ioutil.WriteFile(dl.temp(), make([]byte, 8), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  dl.parts = &parts{free: []region{{3, 5}}}
+  c.saveQueue()
+
+  c = NewClient()
+  c.CacheDir = wd
+  if err := c.LoadQueue(); err != nil { t.Fatal(err) }
+  loaded := c.peers["bar"].dls[0]
+  if len(loaded.sources) != 2 || loaded.sources[1] != (Source{"other", "y"}) {
+    t.Error(loaded.sources)
+  }
+  if loaded.offset != 3 || loaded.size != 7 {
+    t.Error(loaded.offset, loaded.size)
+  }
+}
+
+/* Connects another peer to a client which was set up with setupPeer */
+func connectPeer(t *testing.T, c *Client, nick string,
+                 supports string) (*bufio.Reader, *bufio.Writer,
+                                   *io.PipeWriter) {
+  _in, peerout := io.Pipe()
+  peerin, _out := io.Pipe()
+  go func() {
+    err := c.handlePeer(peerin, peerout, false)
+    peerin.Close()
+    peerout.Close()
+    if err != nil && err != io.EOF { t.Error(err) }
+  }()
+  in, out := bufio.NewReader(_in), bufio.NewWriter(_out)
+  handshakeAs(t, in, out, nick, supports)
+  return in, out, _out
+}
+
+func Test_SegmentedDownload(t *testing.T) {
+  defer withSegments(8, 3)()
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  c.DL.Cnt = 2
+  handshake(t, in, out, "ADCGet")
+
+  data := "0123456789abcdefghij"
+  dl := segmentedSetup(t, c, data)
+  go c.download(dl)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 8" { t.Fatal(string(m.data)) }
+
+  /* the other source is handed what's next as soon as it's around */
+  bin, bout, _bout := connectPeer(t, c, "baz", "ADCGet")
+  defer _bout.Close()
+  getcmd(t, bin, "ADCGET", &m)
+  if string(m.data) != "file x 8 8" { t.Fatal(string(m.data)) }
+  xsend(t, bout, "$ADCSND file x 8 8|89abcdef")
+  getcmd(t, bin, "ADCGET", &m)
+  if string(m.data) != "file x 16 4" { t.Fatal(string(m.data)) }
+  xsend(t, bout, "$ADCSND file x 16 4|ghij")
+
+  /* and then takes over from the one which hasn't sent anything */
+  getcmd(t, bin, "ADCGET", &m)
+  if string(m.data) != "file x 4 4" { t.Fatal(string(m.data)) }
+  queue := c.Queue()
+  if len(queue) != 1 || queue[0].State != Active || queue[0].Done != 12 {
+    t.Error(queue)
+  }
+  xsend(t, bout, "$ADCSND file x 4 4|4567")
+
+  /* which gets hung up on once it gets to where it was cut off */
+  c.Lock()
+  p := c.peers["bar"]
+  c.Unlock()
+  xsend(t, out, "$ADCSND file f 0 8|01234567")
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+  <-p.dead
+  got, err := ioutil.ReadFile(filepath.Join(c.DownloadRoot, "f"))
+  if err != nil || string(got) != data { t.Error(string(got), err) }
+  c.Lock()
+  if len(c.segmented) != 0 { t.Error(c.segmented) }
+  c.Unlock()
+}
+
+/* Sets up a download of some data from bar which baz has too */
+func segmentedSetup(t *testing.T, c *Client, data string) *download {
+  tree := tth.New()
+  tree.Write([]byte(data))
+  root := tth.Encode(tree.Sum(nil))
+  list := &FileListing{}
+  list.Files = []*File{{Name: "x", Size: ByteSize(len(data)), TTH: root}}
+  c.Lock()
+  c.lists["baz"] = list
+  c.Unlock()
+  dl := NewDownloadFile("bar", "/f", &File{Size: ByteSize(len(data)),
+                                           TTH: root})
+  dl.done = make(chan error, 1)
+  return dl
+}
+
+func Test_SegmentLeftover(t *testing.T) {
+  defer withSegments(8, 3)()
+  var m method
+  var buf bytes.Buffer
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  c.DL.Cnt = 2
+  handshake(t, in, out, "ADCGet")
+  c.Lock()
+  c.Hub.write = bufio.NewWriter(&buf)
+  c.Hub.nicks["bar"] = &NickInfo{}
+  p := c.peers["bar"]
+  c.Unlock()
+
+  data := "0123456789abcdefghij"
+  dl := segmentedSetup(t, c, data)
+  go c.download(dl)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 8" { t.Fatal(string(m.data)) }
+
+  /* baz takes over the end of bar's part, but goes away part way through */
+  bin, bout, _bout := connectPeer(t, c, "baz", "ADCGet")
+  getcmd(t, bin, "ADCGET", &m)
+  xsend(t, bout, "$ADCSND file x 8 8|89abcdef")
+  getcmd(t, bin, "ADCGET", &m)
+  xsend(t, bout, "$ADCSND file x 16 4|ghij")
+  getcmd(t, bin, "ADCGET", &m)
+  if string(m.data) != "file x 4 4" { t.Fatal(string(m.data)) }
+  c.Lock()
+  q := c.peers["baz"]
+  c.Unlock()
+  xsend(t, bout, "$ADCSND file x 4 4|45")
+  _bout.Close()
+  <-q.dead
+
+  /* bar is hung up on where it was cut off, but can still fetch the rest */
+  xsend(t, out, "$ADCSND file f 0 8|01234567")
+  <-p.dead
+  c.Lock()
+  failed := len(c.failed)
+  c.Unlock()
+  if failed != 0 { t.Fatal("failed") }
+  in, out, _out = connectPeer(t, c, "bar", "ADCGet")
+  defer _out.Close()
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 6 2" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$ADCSND file f 6 2|67")
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+  got, err := ioutil.ReadFile(filepath.Join(c.DownloadRoot, "f"))
+  if err != nil || string(got) != data { t.Error(string(got), err) }
+}
+
+func Test_SegmentStranded(t *testing.T) {
+  defer withSegments(8, 3)()
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  c.DL.Cnt = 2
+  handshake(t, in, out, "ADCGet")
+  c.Lock()
+  p := c.peers["bar"]
+  c.Unlock()
+
+  data := "0123456789abcdefghij"
+  dl := segmentedSetup(t, c, data)
+  go c.download(dl)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 8" { t.Fatal(string(m.data)) }
+
+  /* baz can't be asked for parts, so once bar goes it gets all the rest */
+  bin, bout, _bout := connectPeer(t, c, "baz", "")
+  defer _bout.Close()
+  xsend(t, out, "$ADCSND file f 0 8|0123")
+  _out.Close()
+  getcmd(t, bin, "Get", &m)
+  if string(m.data) != "x$5" { t.Fatal(string(m.data)) }
+  <-p.dead
+  xsend(t, bout, "$FileLength 16|")
+  getcmd(t, bin, "Send", &m)
+  xsend(t, bout, data[4:])
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+  got, err := ioutil.ReadFile(filepath.Join(c.DownloadRoot, "f"))
+  if err != nil || string(got) != data { t.Error(string(got), err) }
+  c.Lock()
+  if len(c.segmented) != 0 { t.Error(c.segmented) }
+  c.Unlock()
+}
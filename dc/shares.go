@@ -1,33 +1,62 @@
 package dc
 
 import "encoding/xml"
+import "errors"
 import "io"
 import "os"
-import "os/exec"
 import "path/filepath"
 import "regexp"
+import "strings"
+import "sync/atomic"
 import "time"
 
+import "github.com/alexcrichton/fargo/dc/bzip2"
 import "github.com/alexcrichton/fargo/dc/tth"
 
 type Shares struct {
   newShares chan *Share
   delShares chan string
+  filters   chan ShareFilter
+  settings  chan HashSettings
   queries   chan fileQuery
+  lists     chan listQuery
+  verifies  chan verification
   hashers   chan *File
   cmds      chan command
-  idle      chan string
+  idle      chan hashed
   stats     chan ShareStats
+  changes   chan string
   waiter    *fileQuery
 
+  /* filesystem watching, polling is used when the watcher isn't available */
+  watcher   *watcher
+  polling   bool
+  pending   map[string]bool
+
   /* hashing statistics */
-  toHash    []*File
+  toHash    hashQueue
   hashing   map[string]*File
-  tthMap    map[string]*File
+  db        *hashDB
+
+  /* downloaded files waiting to be checked, which go ahead of shared files */
+  toVerify  []verification
+  verifying map[*File]chan string
+
+  /* hashing controls, workers are told to stop by handing them nil */
+  config    HashSettings
+  retiring  int
+  limit     *rateLimiter
+  leaves    int32 /* read by the workers, so accessed atomically */
+
+  /* indices of the shared files, kept up to date with the list */
+  tthMap    tthIndex
+  paths     map[string]*File
+  keys      map[fileKey]*File
 
   /* overall statistics */
   list      *FileListing
   shares    map[string]*Share
+  filter    ShareFilter
 }
 
 type ShareStats struct {
@@ -35,6 +64,8 @@ type ShareStats struct {
   ToHash  int
   ToHashSize ByteSize
   Hashing map[string]float32
+  Filter  ShareFilter
+  Hash    HashSettings
 }
 
 type command int
@@ -45,9 +76,37 @@ const (
 )
 
 type Share struct {
-  Dir     string
-  Name    string
-  Size    ByteSize
+  Dir      string
+  Name     string
+  Size     ByteSize
+  Filter   ShareFilter
+  Excluded int
+
+  excluded map[string]bool /* the paths counted as excluded */
+}
+
+/* State kept while walking a share, used to detect directories and files which
+ * are reached more than once through symlinks */
+type scan struct {
+  share   *Share
+  root    string
+  visited map[fileKey]bool
+  partial bool /* only part of the share is being looked at again */
+}
+
+/* Identifies a file by device and inode, or by its resolved path on platforms
+ * where inodes aren't available */
+type fileKey struct {
+  dev  uint64
+  ino  uint64
+  path string
+}
+
+/* The result of hashing a file, handed back to the hashing goroutine */
+type hashed struct {
+  file   *File
+  tth    string
+  leaves []byte
 }
 
 type fileQuery struct {
@@ -56,25 +115,75 @@ type fileQuery struct {
   wait      bool
 }
 
+/* A file outside of the shares to be hashed, the TTH is sent back */
+type verification struct {
+  file     *File
+  response chan string
+}
+
+type listQuery struct {
+  dir       string
+  recursive bool
+  response  chan []byte
+}
+
 var MaxWorkers = 1
 var tthPattern = regexp.MustCompile("TTH/(\\w+)")
 
+/* How often shares are rescanned when they can't be watched, and how long to
+ * collect filesystem events before applying them to the file list */
+var RescanInterval = 15 * time.Minute
+var WatchDelay = 2 * time.Second
+
+var WatchLimit = errors.New("inotify watch limit reached")
+
 func NewShares() Shares {
   return Shares{newShares: make(chan *Share),
                 delShares: make(chan string),
+                filters:   make(chan ShareFilter),
+                settings:  make(chan HashSettings),
                 queries:   make(chan fileQuery),
+                lists:     make(chan listQuery),
+                verifies:  make(chan verification),
+                verifying: make(map[*File]chan string),
                 hashers:   make(chan *File),
                 cmds:      make(chan command),
-                idle:      make(chan string, MaxWorkers),
+                idle:      make(chan hashed, MaxWorkers),
                 stats:     make(chan ShareStats),
-                toHash:    make([]*File, 0),
-                tthMap:    make(map[string]*File)}
+                changes:   make(chan string),
+                pending:   make(map[string]bool),
+                limit:     &rateLimiter{},
+                tthMap:    make(tthIndex),
+                paths:     make(map[string]*File),
+                keys:      make(map[fileKey]*File),
+                db:        newHashDB()}
 }
 
 func (c *Client) Share(name, dir string) error {
   return c.shares.add(name, dir)
 }
 
+/* Shares a directory, additionally excluding anything matching the filter */
+func (c *Client) ShareWith(name, dir string, filter ShareFilter) error {
+  return c.shares.addWith(name, dir, filter)
+}
+
+/* Replaces the exclusion rules which apply to all shares. Everything is
+ * rescanned so entries which are now excluded disappear from the list. */
+func (c *Client) SetShareFilter(filter ShareFilter) {
+  c.shares.filters <- filter
+}
+
+/* Changes how many files are hashed at once, how fast they're read, and in
+ * which order they're hashed */
+func (c *Client) SetHashSettings(settings HashSettings) error {
+  if settings.Workers < 1 {
+    return errors.New("at least one hasher is needed")
+  }
+  c.shares.settings <- settings
+  return nil
+}
+
 func (c *Client) Unshare(name string) error {
   return c.shares.remove(name)
 }
@@ -96,42 +205,50 @@ func (s *Shares) save(c *Client, xmlFile *File) {
     }
   }()
 
-  /* Create the necessary directories and get a handle on the file */
+  /* Create the necessary directories and get a handle on a temporary file so
+   * peers never see a half-written list */
   err = os.MkdirAll(c.CacheDir, os.FileMode(0755))
   if err != nil { return }
-  file, err := os.Create(filepath.Join(c.CacheDir, "files.xml"))
+  dst := filepath.Join(c.CacheDir, FileList)
+  file, err := os.Create(dst + ".tmp")
   if err != nil { return }
+  defer func() {
+    if err != nil { os.Remove(file.Name()) }
+  }()
   defer file.Close()
 
-  /* Write out the contents to the file */
-  _, err = file.WriteString(xml.Header)
+  /* Write out the compressed contents, hashing them along the way */
+  hash := tth.New()
+  out := bzip2.NewWriter(io.MultiWriter(file, hash))
+  _, err = io.WriteString(out, xml.Header)
   if err != nil { return }
-  enc := xml.NewEncoder(file)
+  enc := xml.NewEncoder(out)
   err = enc.Encode(s.list)
   if err != nil { return }
-  file.Close() /* flush contents, above defer will just return error */
-
-  /* Unfortunately bzip2.Writer does not exist, so we're forced to shell out */
-  cmd := exec.Command("bzip2", "-f", file.Name())
-  err = cmd.Run()
+  err = out.Close()
   if err != nil { return }
-
-  file, err = os.Open(file.Name() + ".bz2")
+  err = file.Sync()
   if err != nil { return }
   info, err := file.Stat()
   if err != nil { return }
+  err = file.Close()
+  if err != nil { return }
+  err = os.Rename(file.Name(), dst)
+  if err != nil { return }
+
   xmlFile.Size = ByteSize(info.Size())
-  xmlFile.realpath = file.Name()
+  xmlFile.realpath = dst
+  s.setTTH(xmlFile, tth.Encode(hash.Sum(nil)))
 
-  s.buildTTHMap(&s.list.Directory)
+  /* Keep the hash database in sync with what we're advertising */
+  err = s.db.save(c.CacheDir)
 }
 
-func (s *Shares) buildTTHMap(dir *Directory) {
-  for _, f := range dir.Files {
-    s.tthMap[f.TTH] = f
-  }
-  for i, _ := range dir.Dirs {
-    s.buildTTHMap(&dir.Dirs[i])
+/* Saves the file list, but only if there's no hashing left to do. Otherwise
+ * the list will be saved when the hashers finish */
+func (s *Shares) saveIfIdle(c *Client, xmlFile *File) {
+  if len(s.hashing) == 0 && s.toHash.Len() == 0 {
+    s.save(c, xmlFile)
   }
 }
 
@@ -140,29 +257,53 @@ func (s *Shares) hash(c *Client) {
   s.list = &FileListing{Version: "1.0.0", Generator: "fargo", Base: "/"}
   s.shares = make(map[string]*Share)
   s.hashing = make(map[string]*File)
-  xmlList := File{Name: "files.xml.bz2"}
+  xmlList := File{Name: FileList}
 
+  err = s.db.load(c.CacheDir)
+  if err != nil {
+    c.log("hash database error: " + err.Error())
+  }
+
+  s.config.Workers = MaxWorkers
+  s.config.Leaves = DefaultLeaves
+  atomic.StoreInt32(&s.leaves, int32(DefaultLeaves))
   for i := 0; i < MaxWorkers; i++ {
     go s.worker()
   }
 
-  recheck := time.After(15 * time.Minute)
+  s.watcher, err = newWatcher(s.changes)
+  if err != nil {
+    c.log("watch error: " + err.Error() + ", falling back to polling")
+    s.polling = true
+  }
+  defer func() {
+    if s.watcher != nil { s.watcher.close() }
+  }()
+
+  var recheck, settle <-chan time.Time
   s.save(c, &xmlList)
 
   for {
-    /* Send files to the hashers if we can */
-    done := false
-    for !done && len(s.toHash) > 0 {
-      select {
-        case s.hashers <- s.toHash[0]:
-          s.hashing[s.toHash[0].realpath] = s.toHash[0]
-          s.toHash = s.toHash[1:]
-        default:
-          done = true
-      }
+    /* Only offer work to the hashers if there's something to hash, a nil
+     * channel is never selected */
+    var hashers chan *File
+    var next *File
+    if s.retiring > 0 {
+      hashers = s.hashers
+    } else if len(s.toVerify) > 0 {
+      hashers = s.hashers
+      next = s.toVerify[0].file
+    } else if s.toHash.Len() > 0 {
+      hashers = s.hashers
+      next = s.toHash.peek()
+    }
+
+    if recheck == nil && s.polling {
+      recheck = time.After(RescanInterval)
     }
 
-    if s.waiter != nil {
+    if s.waiter != nil && len(s.hashing) == 0 && s.toHash.Len() == 0 &&
+       len(s.pending) == 0 {
       /* Be sure we've updated tth hashes and saved the file list */
       s.save(c, &xmlList)
       s.waiter.satisfy(s, &xmlList)
@@ -171,6 +312,17 @@ func (s *Shares) hash(c *Client) {
 
     /* wait for some activity via hashers or some command */
     select {
+      case hashers <- next:
+        if next == nil {
+          s.retiring--
+        } else if len(s.toVerify) > 0 && s.toVerify[0].file == next {
+          s.verifying[next] = s.toVerify[0].response
+          s.toVerify = s.toVerify[1:]
+        } else {
+          s.hashing[next.realpath] = next
+          s.toHash.next()
+        }
+
       case share := <-s.newShares:
         if s.shares[share.Name] != nil {
           c.log("hash error: already sharing directory")
@@ -184,43 +336,101 @@ func (s *Shares) hash(c *Client) {
           c.log("hash error: " + err.Error())
         } else {
           s.shares[share.Name] = share
+          s.saveIfIdle(c, &xmlList)
         }
 
+      case settings := <-s.settings:
+        s.configure(settings)
+
+      case filter := <-s.filters:
+        s.filter = filter
+        s.rescanShares(c)
+        s.saveIfIdle(c, &xmlList)
+
       case share := <-s.delShares:
+        if sh := s.shares[share]; sh != nil && s.watcher != nil {
+          s.watcher.remove(sh.Dir)
+        }
         delete(s.shares, share)
-        s.list.removeDirName(share)
+        if dir := s.list.childDir(share); dir != nil {
+          s.forget(dir)
+          s.list.removeDirName(share)
+        }
 
       case <-recheck:
-        recheck = time.After(15 * time.Minute)
+        recheck = nil
         s.rescanShares(c)
+        s.saveIfIdle(c, &xmlList)
+
+      /* Batch up filesystem events for a bit because they tend to come in
+       * bursts, and then regenerate the list once they've all been applied */
+      case path := <-s.changes:
+        s.pending[path] = true
+        if settle == nil {
+          settle = time.After(WatchDelay)
+        }
+
+      case <-settle:
+        settle = nil
+        s.applyChanges(c)
+        s.saveIfIdle(c, &xmlList)
 
       case cmd := <-s.cmds:
         switch cmd {
-          case stop:    return
-          case rescan:  s.rescanShares(c)
+          case stop:
+            err = s.db.save(c.CacheDir)
+            if err != nil {
+              c.log("hash database error: " + err.Error())
+            }
+            return
+          case rescan:
+            s.rescanShares(c)
+            s.saveIfIdle(c, &xmlList)
           case getstats:
             stats := ShareStats{Hashing: make(map[string]float32),
-                                Shares:  make([]Share, 0)}
+                                Shares:  make([]Share, 0),
+                                Filter:  s.filter,
+                                Hash:    s.config}
 
             for _, sh := range s.shares {
-              stats.Shares = append(stats.Shares, *sh)
+              share := *sh
+              share.excluded = nil /* the count is all that's handed out */
+              stats.Shares = append(stats.Shares, share)
             }
-            stats.ToHash = len(s.toHash)
-            for _, file := range(s.toHash) {
-              stats.ToHashSize += file.Size
+            stats.ToHash = s.toHash.Len()
+            for _, e := range s.toHash.entries {
+              stats.ToHashSize += e.file.Size
             }
             for file, info := range(s.hashing) {
-              stats.Hashing[file] = float32(info.hashProgress) /
-                                    float32(info.Size)
+              progress := atomic.LoadUint64(&info.hashProgress)
+              stats.Hashing[file] = float32(progress) / float32(info.Size)
             }
             s.stats <- stats
         }
 
-      case path := <-s.idle:
-        delete(s.hashing, path)
-        if len(s.hashing) == 0 && len(s.toHash) == 0 {
-          s.save(c, &xmlList)
+      case v := <-s.verifies:
+        s.toVerify = append(s.toVerify, v)
+
+      case res := <-s.idle:
+        f := res.file
+        if response := s.verifying[f]; response != nil {
+          delete(s.verifying, f)
+          response <- res.tth
+          break
         }
+        delete(s.hashing, f.realpath)
+        /* The file may have been removed while it was being hashed */
+        if s.paths[f.path] == f {
+          s.setTTH(f, res.tth)
+          if f.TTH != "fail" {
+            err = s.db.storeLeaves(f.TTH, res.leaves)
+            if err != nil {
+              c.log("hash database error: " + err.Error())
+            }
+            s.db.update(f)
+          }
+        }
+        s.saveIfIdle(c, &xmlList)
         /* Next iteration will queue up another file to hash */
 
       case q := <-s.queries:
@@ -229,8 +439,33 @@ func (s *Shares) hash(c *Client) {
         } else {
           q.satisfy(s, &xmlList)
         }
+
+      case q := <-s.lists:
+        q.response <- s.list.partial(q.dir, q.recursive)
+    }
+  }
+}
+
+/* Starts or stops workers to match the requested number, cancelling pending
+ * stops before starting anyone new */
+func (s *Shares) configure(settings HashSettings) {
+  for ; s.config.Workers < settings.Workers; s.config.Workers++ {
+    if s.retiring > 0 {
+      s.retiring--
+    } else {
+      go s.worker()
     }
   }
+  if s.config.Workers > settings.Workers {
+    s.retiring += s.config.Workers - settings.Workers
+  }
+  if settings.Leaves <= 0 {
+    settings.Leaves = DefaultLeaves
+  }
+  s.config = settings
+  s.limit.set(settings.Rate)
+  atomic.StoreInt32(&s.leaves, int32(settings.Leaves))
+  s.toHash.setOrder(settings.Order)
 }
 
 func (s *Shares) rescanShares(c *Client) {
@@ -246,6 +481,242 @@ func (s *Shares) rescanShares(c *Client) {
   }
 }
 
+/* Applies all pending filesystem events to the file list. Each changed path is
+ * re-examined on its own, so creations, modifications, deletions and renames
+ * are all handled the same way. */
+func (s *Shares) applyChanges(c *Client) {
+  changed := make(map[*Share]bool)
+  for path, _ := range s.pending {
+    delete(s.pending, path)
+
+    /* events were lost, so fall back to looking at everything */
+    if path == "" {
+      s.rescanShares(c)
+      for k, _ := range s.pending {
+        delete(s.pending, k)
+      }
+      return
+    }
+
+    for _, sh := range s.shares {
+      rel, err := filepath.Rel(sh.Dir, path)
+      if err != nil || rel == ".." ||
+         strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+        continue
+      }
+      err = s.change(c, sh, rel)
+      if err != nil {
+        c.log("watch error: " + err.Error())
+      }
+      changed[sh] = true
+    }
+  }
+
+  /* Share sizes aren't tracked incrementally, so recompute them */
+  for sh, _ := range changed {
+    sh.Size = 0
+    dir := s.list.childDir(sh.Name)
+    if dir != nil {
+      dir.visit("", func(f *File, _ string) error {
+        sh.Size += f.Size
+        return nil
+      })
+    }
+  }
+}
+
+/* Updates the listing for one changed path relative to a share's root */
+func (s *Shares) change(c *Client, sh *Share, rel string) error {
+  if rel == "." {
+    return s.sync(c, sh)
+  }
+
+  /* Find the closest ancestor which we already know about, and look at the
+   * entry underneath it which leads to the changed path */
+  parts := strings.Split(filepath.ToSlash(rel), "/")
+  parent := s.list.childDir(sh.Name)
+  if parent == nil { return s.sync(c, sh) }
+  i := 0
+  for ; i < len(parts) - 1; i++ {
+    next := parent.childDir(parts[i])
+    if next == nil { break }
+    parent = next
+  }
+  name := parts[i]
+  relpath := filepath.Join(parts[0:i+1]...)
+  realpath := filepath.Join(sh.Dir, relpath)
+
+  /* Everything above the changed path has already been visited */
+  sc := newScan(sh)
+  sc.partial = true
+  for dir := filepath.Dir(realpath); ; dir = filepath.Dir(dir) {
+    if info, err := os.Stat(dir); err == nil {
+      sc.visit(dir, info)
+    }
+    if dir == sh.Dir || dir == filepath.Dir(dir) { break }
+  }
+
+  info, err := os.Lstat(realpath)
+  excluded := false
+  if err == nil {
+    info, excluded, err = s.admit(sc, realpath, relpath, info)
+  }
+  sh.exclude(realpath, excluded)
+  if err == nil && info == nil && !excluded {
+    return nil /* already shared through some other path */
+  }
+  if os.IsNotExist(err) || info == nil {
+    /* The path was deleted, moved away or excluded, so forget about it */
+    if dir := parent.childDir(name); dir != nil {
+      s.forget(dir)
+      parent.removeDirName(name)
+    } else if file := parent.childFile(name); file != nil {
+      s.drop(file)
+      parent.removeFileName(name)
+    }
+    if s.watcher != nil {
+      s.watcher.remove(realpath)
+    }
+    if !excluded {
+      sh.forgetExcluded(realpath)
+    }
+    if os.IsNotExist(err) { return nil }
+    return err
+  }
+  f, err := os.Open(realpath)
+  if err != nil { return err }
+  defer f.Close()
+
+  /* Everything underneath the parent which isn't seen again gets pruned */
+  s.list.Directory.version++
+  parent.version = s.list.Directory.version
+  return s.file(c, sc, f, info, parent, name)
+}
+
+func resolvedPath(path string) string {
+  resolved, err := filepath.EvalSymlinks(path)
+  if err != nil {
+    return path
+  }
+  return resolved
+}
+
+func newScan(sh *Share) *scan {
+  return &scan{share: sh, root: resolvedPath(sh.Dir),
+               visited: make(map[fileKey]bool)}
+}
+
+/* Records that an entry has been reached, returning false if it already had
+ * been reached before during this scan */
+func (sc *scan) visit(path string, info os.FileInfo) bool {
+  key := keyOf(path, info)
+  if sc.visited[key] { return false }
+  sc.visited[key] = true
+  return true
+}
+
+/* Whether a path with all symlinks resolved lies within the share */
+func (sc *scan) contains(resolved string) bool {
+  return resolved == sc.root ||
+         strings.HasPrefix(resolved, sc.root + string(filepath.Separator))
+}
+
+/* Decides whether an entry found while scanning should be shared. Symlinks are
+ * resolved according to the symlink policy, and the returned information is
+ * about whatever the entry refers to. A nil return means the entry should be
+ * skipped, either because it's excluded or because its contents were already
+ * reached through another path. */
+func (s *Shares) admit(sc *scan, path, rel string,
+                       info os.FileInfo) (os.FileInfo, bool, error) {
+  link := info.Mode() & os.ModeSymlink != 0
+  if link {
+    policy := s.filter.Symlinks
+    if sc.share.Filter.Symlinks > policy {
+      policy = sc.share.Filter.Symlinks
+    }
+    if policy == SkipSymlinks { return nil, true, nil }
+    target, err := os.Stat(path)
+    if err != nil { return nil, false, err }
+    if policy == FollowSymlinksWithinShare {
+      resolved, err := filepath.EvalSymlinks(path)
+      if err != nil { return nil, false, err }
+      if !sc.contains(resolved) { return nil, true, nil }
+    }
+    info = target
+  }
+
+  if s.excluded(sc.share, rel, info) { return nil, true, nil }
+  /* directories are checked for loops when they're descended into, and files
+   * are only shared through whichever path reached them first. Looking at
+   * part of a share again only reaches some of the paths, so what's already
+   * shared counts as having been reached first. */
+  if !info.IsDir() && !sc.visit(path, info) { return nil, false, nil }
+  if !info.IsDir() && sc.partial && s.sharedAs(sc, path, rel, info) {
+    return nil, false, nil
+  }
+  return info, false, nil
+}
+
+/* Whether a file is already shared in the share being scanned through some
+ * other path which is still there */
+func (s *Shares) sharedAs(sc *scan, path, rel string,
+                          info os.FileInfo) bool {
+  other := s.keys[keyOf(path, info)]
+  self := s.paths[sc.share.Name + "/" + filepath.ToSlash(rel)]
+  if other == nil || other == self ||
+     !strings.HasPrefix(other.path, sc.share.Name + "/") {
+    return false
+  }
+  still, err := os.Stat(other.realpath)
+  return err == nil && keyOf(other.realpath, still) == other.key
+}
+
+/* Drops all knowledge of the files underneath a directory */
+func (s *Shares) forget(dir *Directory) {
+  dir.visit("", func(f *File, _ string) error {
+    s.drop(f)
+    return nil
+  })
+}
+
+/* Watches a directory for changes, switching over to polling if the watcher
+ * can't keep track of any more directories */
+func (s *Shares) watch(c *Client, dir string) {
+  if s.watcher == nil { return }
+  err := s.watcher.add(dir)
+  if err == WatchLimit && !s.polling {
+    c.log("watch error: " + err.Error() + ", falling back to polling")
+    s.polling = true
+  } else if err != nil && err != WatchLimit {
+    c.log("watch error: " + err.Error())
+  }
+}
+
+/* Counts an entry as excluded or not, so that the count stays right as the
+ * entries are looked at again one at a time */
+func (sh *Share) exclude(path string, excluded bool) {
+  if excluded {
+    if sh.excluded == nil {
+      sh.excluded = make(map[string]bool)
+    }
+    sh.excluded[path] = true
+  } else {
+    delete(sh.excluded, path)
+  }
+  sh.Excluded = len(sh.excluded)
+}
+
+/* Stops counting anything excluded underneath a path which has gone away */
+func (sh *Share) forgetExcluded(dir string) {
+  prefix := dir + string(filepath.Separator)
+  for path, _ := range sh.excluded {
+    if path == dir || strings.HasPrefix(path, prefix) {
+      delete(sh.excluded, path)
+    }
+  }
+  sh.Excluded = len(sh.excluded)
+}
+
 func (s *Shares) sync(c *Client, sh *Share) error {
   file, err := os.Open(sh.Dir)
   if err != nil { return err }
@@ -253,42 +724,67 @@ func (s *Shares) sync(c *Client, sh *Share) error {
   if err != nil { return err }
   s.list.Directory.version++
   sh.Size = ByteSize(0)
-  err = s.file(file, stat, &s.list.Directory, sh.Name, sh)
+  sh.excluded = nil
+  sh.Excluded = 0
+  err = s.file(c, newScan(sh), file, stat, &s.list.Directory, sh.Name)
   file.Close()
   if err != nil { return err }
 
   return nil
 }
 
-func (s *Shares) file(f *os.File, info os.FileInfo, d *Directory,
-                      name string, sh *Share) error {
+func (s *Shares) file(c *Client, sc *scan, f *os.File, info os.FileInfo,
+                      d *Directory, name string) error {
+  sh := sc.share
 
   if !info.IsDir() {
-    d.removeDirName(name)
+    if dir := d.childDir(name); dir != nil {
+      s.forget(dir)
+      d.removeDirName(name)
+    }
     file := d.childFile(name)
     if file == nil {
-      file = &File{Name: name, realpath: f.Name()}
-      d.Files = append(d.Files, file)
+      file = s.newFile(d, name, f.Name())
+    }
+    if s.keys[file.key] == file {
+      delete(s.keys, file.key)
     }
+    file.key = keyOf(f.Name(), info)
+    s.keys[file.key] = file
     file.Size = ByteSize(info.Size())
     sh.Size += file.Size
     if info.ModTime().After(file.mtime) || file.TTH == "" {
+      /* Only hash files which we haven't seen before or which have changed
+       * since they were last hashed */
       file.mtime = info.ModTime()
-      file.TTH = ""
-      s.toHash = append(s.toHash, file)
+      file.inode = inode(info)
+      s.setTTH(file, s.db.lookup(file.realpath, info))
+      if file.TTH == "" {
+        s.toHash.add(file)
+      }
     }
     file.version = d.version
     return nil
   }
 
+  /* Symlinks can make a directory show up more than once, possibly within
+   * itself, so only ever descend into it once */
+  if !sc.visit(f.Name(), info) {
+    c.log("scan: skipping already visited directory: " + f.Name())
+    return nil
+  }
+
   /* For a directory, descend into each file/directory */
-  d.removeFileName(name)
+  if file := d.childFile(name); file != nil {
+    s.drop(file)
+    d.removeFileName(name)
+  }
   dir := d.childDir(name)
   if dir == nil {
-    d.Dirs = append(d.Dirs, NewDirectory(name, f.Name()))
-    dir = &d.Dirs[len(d.Dirs) - 1]
+    dir = s.newDir(d, name, f.Name())
   }
   dir.version = d.version
+  s.watch(c, f.Name())
 
   /* Ensure all current files update to the current version and are hashed */
   for {
@@ -299,24 +795,38 @@ func (s *Shares) file(f *os.File, info os.FileInfo, d *Directory,
       return err
     }
 
+    /* Problems with individual entries shouldn't stop the whole scan */
     for _, info := range infos {
-      f2, err := os.Open(filepath.Join(f.Name(), info.Name()))
+      path := filepath.Join(f.Name(), info.Name())
+      rel, err := filepath.Rel(sh.Dir, path)
       if err != nil { return err }
-      s.file(f2, info, dir, info.Name(), sh)
-      f2.Close()
+      target, excluded, err := s.admit(sc, path, rel, info)
+      sh.exclude(path, excluded)
+      if err == nil && target != nil {
+        var f2 *os.File
+        f2, err = os.Open(path)
+        if err == nil {
+          err = s.file(c, sc, f2, target, dir, info.Name())
+          f2.Close()
+        }
+      }
+      if err != nil {
+        c.log("scan error: " + err.Error())
+      }
     }
   }
 
   /* Prune out old files and directories */
   for i := 0; i < len(dir.Dirs); i++ {
     if dir.Dirs[i].version != dir.version {
+      s.forget(&dir.Dirs[i])
       dir.removeDir(i)
       i--
     }
   }
   for i := 0; i < len(dir.Files); i++ {
     if dir.Files[i].version != dir.version {
-      delete(s.tthMap, dir.Files[i].TTH)
+      s.drop(dir.Files[i])
       dir.removeFile(i)
       i--
     }
@@ -337,20 +847,42 @@ func (s *Shares) queryWait(path string) *File {
   return <-response
 }
 
+/* Generates the uncompressed listing of a shared directory for a peer which is
+ * browsing lazily */
+func (s *Shares) partialList(dir string, recursive bool) ([]byte, error) {
+  response := make(chan []byte)
+  s.lists <- listQuery{dir: dir, recursive: recursive, response: response}
+  data := <-response
+  if data == nil { return nil, DirectoryNotFound }
+  return data, nil
+}
+
 func (q *fileQuery) satisfy(s *Shares, xmlList *File) {
   matches := tthPattern.FindStringSubmatch(q.path)
   if len(matches) == 2 && len(matches[1]) > 0 {
-    q.response <- s.tthMap[matches[1]]
-  } else if q.path == "files.xml.bz2" {
+    q.response <- s.tthMap.find(matches[1])
+  } else if q.path == FileList {
     q.response <- xmlList
   } else {
-    f, _ := s.list.FindFile(q.path)
-    q.response <- f
+    q.response <- s.paths[strings.TrimPrefix(q.path, "/")]
   }
 }
 
+/* Hashes a file which isn't shared, waiting for one of the workers to get
+ * around to it */
+func (s *Shares) hashFile(path string, size ByteSize) string {
+  v := verification{file:     &File{realpath: path, Size: size},
+                    response: make(chan string, 1)}
+  s.verifies <- v
+  return <-v.response
+}
+
 func (s *Shares) add(name, dir string) error {
-  s.newShares <- &Share{Dir: dir, Name: name}
+  return s.addWith(name, dir, ShareFilter{})
+}
+
+func (s *Shares) addWith(name, dir string, filter ShareFilter) error {
+  s.newShares <- &Share{Dir: dir, Name: name, Filter: filter}
   return nil
 }
 
@@ -374,18 +906,23 @@ func (s *Shares) halt() {
 
 func (s *Shares) worker() {
   for info := range s.hashers {
+    if info == nil { return } /* there are too many workers */
     file, err := os.Open(info.realpath)
     hash := ""
+    var leaves []byte
     if err == nil {
-      info.hashProgress = 0
-      hash, err = tth.Hash(file, uint64(info.Size), &info.hashProgress)
+      atomic.StoreUint64(&info.hashProgress, 0)
+      in := &throttled{r: file, limit: s.limit}
+      max := int(atomic.LoadInt32(&s.leaves))
+      hash, leaves, err = tth.HashLeaves(in, uint64(info.Size), max,
+                                         func(n int) {
+        atomic.AddUint64(&info.hashProgress, uint64(n))
+      })
       file.Close()
     }
-    if err == nil {
-      info.TTH = hash
-    } else {
-      info.TTH = "fail"
+    if err != nil {
+      hash = "fail"
     }
-    s.idle <- info.realpath
+    s.idle <- hashed{file: info, tth: hash, leaves: leaves}
   }
 }
@@ -1,9 +1,14 @@
 package dc
 
 import "testing"
+import "compress/bzip2"
+import "fmt"
 import "io/ioutil"
 import "os"
 import "path/filepath"
+import "strconv"
+import "strings"
+import "time"
 
 func stub_fs(t *testing.T) {
   err := os.MkdirAll("foo/bar/baz", os.FileMode(0755))
@@ -79,6 +84,20 @@ func Test_ScanShares(t *testing.T) {
   if f == nil { t.Fatal() }
   if f.realpath != wd + "/cache/files.xml.bz2" { t.Error(f.realpath) }
   if f.Size == 0 { t.Error() }
+
+  /* the list is written atomically, compressed, and addressable by TTH */
+  if _, err := os.Stat(f.realpath + ".tmp"); !os.IsNotExist(err) {
+    t.Error(err)
+  }
+  handle, err := os.Open(f.realpath)
+  if err != nil { t.Fatal(err) }
+  defer handle.Close()
+  list := &FileListing{}
+  err = ParseFileList(bzip2.NewReader(handle), list)
+  if err != nil { t.Fatal(err) }
+  if _, err := list.FindFile("name/bar/a"); err != nil { t.Error(err) }
+  if f.TTH == "" { t.Fatal() }
+  if shares.queryWait("TTH/" + f.TTH) != f { t.Error() }
 }
 
 func Test_AddRemoveShares(t *testing.T) {
@@ -128,3 +147,182 @@ func Test_ChangingShares(t *testing.T) {
   shares.update()
   if shares.query("name/bar/baz/a") != nil { t.Error() }
 }
+
+func Test_ExcludedEntries(t *testing.T) {
+  shares, wd := setup(t)
+  defer teardown(shares, wd)
+  err := os.MkdirAll("foo/.git", os.FileMode(0755))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("foo/.git/HEAD", []byte("a"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("foo/.a.swp", []byte("a"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("foo/big.iso", []byte("abcdef"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("foo/bar/x.part", []byte("a"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+
+  shares.addWith("name", "foo", ShareFilter{HideDotfiles: true,
+                                            Globs: []string{"*.part"},
+                                            DenyExts: []string{"ISO"}})
+  if shares.queryWait("name/a") == nil { t.Error() }
+  if shares.query("name/.git/HEAD") != nil { t.Error() }
+  if shares.query("name/.a.swp") != nil { t.Error() }
+  if shares.query("name/big.iso") != nil { t.Error() }
+  if shares.query("name/bar/x.part") != nil { t.Error() }
+
+  /* excluded files aren't hashed, so they can't be found via TTH either */
+  if shares.query("TTH/PBLVGEHI5EC22IUDPQGSKBV2PDG6PFM2GM5QLOI") != nil {
+    t.Error()
+  }
+  shares.cmds <- getstats
+  stats := <-shares.stats
+  if len(stats.Shares) != 1 { t.Fatal(stats.Shares) }
+  if stats.Shares[0].Excluded != 4 { t.Error(stats.Shares[0].Excluded) }
+
+  /* global rules apply to everything, and changing them rescans */
+  shares.filters <- ShareFilter{MaxSize: 0, MinSize: 2}
+  if shares.queryWait("name/a") != nil { t.Error() }
+  shares.filters <- ShareFilter{AllowExts: []string{"iso"}}
+  if shares.queryWait("name/a") != nil { t.Error() }
+  if shares.query("name/big.iso") != nil { t.Error() }
+  shares.filters <- ShareFilter{}
+  if shares.queryWait("name/a") == nil { t.Error() }
+}
+
+func Test_SymlinkPolicies(t *testing.T) {
+  shares, wd := setup(t)
+  defer teardown(shares, wd)
+  err := os.Mkdir("outside", os.FileMode(0755))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("outside/x", []byte("x"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  for _, link := range [][]string{{"..", "foo/bar/loop"},
+                                  {"../outside", "foo/ext"},
+                                  {"../outside/x", "foo/l1"},
+                                  {"../outside/x", "foo/l2"},
+                                  {"a", "foo/la"},
+                                  {"nowhere", "foo/dangling"}} {
+    err = os.Symlink(link[0], link[1])
+    if err != nil { t.Fatal(err) }
+  }
+
+  /* following links doesn't loop forever or share things twice */
+  shares.add("name", "foo")
+  if shares.queryWait("name/bar/a") == nil { t.Error() }
+  if shares.query("name/bar/loop/a") != nil { t.Error() }
+  if shares.query("name/dangling") != nil { t.Error() }
+  /* whichever way a file is reached first, it's only shared once */
+  for _, paths := range [][]string{{"name/ext/x", "name/l1", "name/l2"},
+                                   {"name/a", "name/la"}} {
+    found := 0
+    for _, path := range paths {
+      if shares.query(path) != nil {
+        found++
+      }
+    }
+    if found != 1 { t.Error(paths, found) }
+  }
+
+  /* only links within the share */
+  shares.filters <- ShareFilter{Symlinks: FollowSymlinksWithinShare}
+  if shares.queryWait("name/ext/x") != nil { t.Error() }
+  if shares.query("name/l1") != nil { t.Error() }
+  if shares.query("name/bar/loop/a") != nil { t.Error() }
+
+  /* no links at all */
+  shares.filters <- ShareFilter{Symlinks: SkipSymlinks}
+  if shares.queryWait("name/a") == nil { t.Error() }
+  if shares.query("name/ext/x") != nil { t.Error() }
+  shares.cmds <- getstats
+  stats := <-shares.stats
+  if stats.Shares[0].Excluded != 6 { t.Error(stats.Shares[0].Excluded) }
+}
+
+func Test_IndicesFollowChanges(t *testing.T) {
+  WatchDelay = 10 * time.Millisecond
+  shares, wd := setup(t)
+  defer teardown(shares, wd)
+  shares.add("name", "foo")
+  shares.add("other", "foo/bar")
+
+  /* identical files share a TTH, and losing one doesn't lose the others */
+  tth := "TTH/CZQUWH3IYXBF5L3BGYUGZHASSMXU647IP2IKE4Y"
+  if shares.queryWait(tth) == nil { t.Fatal() }
+  err := os.Remove("foo/a")
+  if err != nil { t.Fatal(err) }
+  shares.cmds <- rescan
+  if shares.queryWait("name/a") != nil { t.Error() }
+  f := shares.query(tth)
+  if f == nil || f.path == "name/a" { t.Error(f) }
+
+  /* files which change are indexed by their new TTH */
+  err = ioutil.WriteFile("foo/b", []byte("dd"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  shares.cmds <- rescan
+  f = shares.queryWait("TTH/FZH4TYZRKFCNCEVOUREOOKQ2U32LUPMSCGYXAMY")
+  if f == nil || f.path != "name/b" { t.Error(f) }
+
+  /* removing a share forgets everything underneath it */
+  shares.remove("name")
+  if shares.query("name/bar/a") != nil { t.Error() }
+  if shares.query("TTH/FZH4TYZRKFCNCEVOUREOOKQ2U32LUPMSCGYXAMY") != nil {
+    t.Error()
+  }
+  f = shares.query(tth)
+  if f == nil || !strings.HasPrefix(f.path, "other/") { t.Error(f) }
+}
+
+/* Builds a share of n files in directories of 1000 each, going through the
+ * same lookups as scanning a share does */
+func benchShares(n int) *Shares {
+  _shares := NewShares()
+  shares := &_shares
+  shares.list = &FileListing{}
+  root := shares.newDir(&shares.list.Directory, "share", "")
+  for i := 0; i < n; i++ {
+    name := strconv.Itoa(i / 1000)
+    dir := root.childDir(name)
+    if dir == nil {
+      dir = shares.newDir(root, name, "")
+    }
+    name = strconv.Itoa(i)
+    if dir.childFile(name) == nil {
+      f := shares.newFile(dir, name, "")
+      shares.setTTH(f, "TTH" + name)
+    }
+  }
+  return shares
+}
+
+func benchmarkScan(b *testing.B, n int) {
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    benchShares(n)
+  }
+}
+
+func benchmarkQuery(b *testing.B, n int) {
+  shares := benchShares(n)
+  xmlList := File{Name: FileList}
+  q := fileQuery{response: make(chan *File, 1)}
+  b.ReportAllocs()
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    j := i % n
+    if i % 2 == 0 {
+      q.path = fmt.Sprintf("share/%d/%d", j / 1000, j)
+    } else {
+      q.path = fmt.Sprintf("TTH/TTH%d", j)
+    }
+    q.satisfy(shares, &xmlList)
+    if <-q.response == nil { b.Fatal(q.path) }
+  }
+}
+
+func BenchmarkScan10k(b *testing.B)   { benchmarkScan(b, 10000) }
+func BenchmarkScan100k(b *testing.B)  { benchmarkScan(b, 100000) }
+func BenchmarkScan1M(b *testing.B)    { benchmarkScan(b, 1000000) }
+func BenchmarkQuery10k(b *testing.B)  { benchmarkQuery(b, 10000) }
+func BenchmarkQuery100k(b *testing.B) { benchmarkQuery(b, 100000) }
+func BenchmarkQuery1M(b *testing.B)   { benchmarkQuery(b, 1000000) }
@@ -0,0 +1,149 @@
+package dc
+
+import "io"
+import "sort"
+import "sync/atomic"
+import "time"
+
+/* Transfers in progress count the bytes going through them as they go, and
+ * the rest is worked out whenever someone asks. The current speed is over
+ * however long it's been since it was last asked for, at least a second, and
+ * the average is over the whole transfer. */
+
+type Direction int
+
+const (
+  DownloadTransfer Direction = iota
+  UploadTransfer
+)
+
+func (d Direction) String() string {
+  if d == UploadTransfer { return "up" }
+  return "down"
+}
+
+/* A transfer as of when it was asked for. Speeds are in bytes per second, and
+ * the ETA is 0 until there's a speed to go by. */
+type Transfer struct {
+  Direction Direction
+  Nick      string
+  File      string
+  Done      int64
+  Total     int64
+  Speed     float64
+  Average   float64
+  ETA       time.Duration
+}
+
+type transfer struct {
+  dir     Direction
+  file    string
+  start   int64 /* where in the file the transfer began */
+  total   int64
+  count   int64 /* updated atomically */
+  started time.Time
+
+  /* for the current speed, only touched with the peer locked */
+  sampled time.Time
+  sample  int64
+  speed   float64
+}
+
+/* How often the current speed is recalculated at most */
+var speedInterval = time.Second
+
+type countingReader struct {
+  r io.Reader
+  n *int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+  n, err := c.r.Read(b)
+  atomic.AddInt64(c.n, int64(n))
+  return n, err
+}
+
+type countingWriter struct {
+  w io.Writer
+  n *int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+  n, err := c.w.Write(b)
+  atomic.AddInt64(c.n, int64(n))
+  return n, err
+}
+
+/* Starts keeping track of a transfer of part of a file which is total bytes
+ * long */
+func (p *peer) track(dir Direction, file string, offset, size,
+                     total int64) *transfer {
+  if total < offset + size {
+    total = offset + size
+  }
+  now := time.Now()
+  t := &transfer{dir: dir, file: file, start: offset, total: total,
+                 started: now, sampled: now}
+  p.Lock()
+  p.xfer = t
+  p.Unlock()
+  return t
+}
+
+func (p *peer) untrack() {
+  p.Lock()
+  p.xfer = nil
+  p.Unlock()
+}
+
+func (t *transfer) reader(r io.Reader) io.Reader {
+  return &countingReader{r: r, n: &t.count}
+}
+
+func (t *transfer) writer(w io.Writer) io.Writer {
+  return &countingWriter{w: w, n: &t.count}
+}
+
+func (t *transfer) snapshot(nick string, now time.Time) Transfer {
+  count := atomic.LoadInt64(&t.count)
+  s := Transfer{Direction: t.dir, Nick: nick, File: t.file,
+                Done: t.start + count, Total: t.total}
+  if elapsed := now.Sub(t.started).Seconds(); elapsed > 0 {
+    s.Average = float64(count) / elapsed
+  }
+  if elapsed := now.Sub(t.sampled); elapsed >= speedInterval {
+    t.speed = float64(count - t.sample) / elapsed.Seconds()
+    t.sample = count
+    t.sampled = now
+  } else if t.sample == 0 {
+    t.speed = s.Average
+  }
+  s.Speed = t.speed
+  if s.Speed > 0 {
+    left := float64(s.Total - s.Done) / s.Speed
+    s.ETA = time.Duration(left * float64(time.Second))
+  }
+  return s
+}
+
+/* Every transfer which is going on at the moment */
+func (c *Client) Transfers() []Transfer {
+  now := time.Now()
+  transfers := make([]Transfer, 0)
+  c.Lock()
+  for _, p := range c.peers {
+    p.Lock()
+    if p.xfer != nil {
+      transfers = append(transfers, p.xfer.snapshot(p.nick, now))
+    }
+    p.Unlock()
+  }
+  c.Unlock()
+  sort.Slice(transfers, func(i, j int) bool {
+    if transfers[i].Nick != transfers[j].Nick {
+      return transfers[i].Nick < transfers[j].Nick
+    }
+    return transfers[i].Direction < transfers[j].Direction
+  })
+  return transfers
+}
@@ -0,0 +1,70 @@
+package dc
+
+import "testing"
+import "time"
+
+func Test_TransferSpeeds(t *testing.T) {
+  start := time.Now()
+  at := func(ms int) time.Time {
+    return start.Add(time.Duration(ms) * time.Millisecond)
+  }
+  xfer := &transfer{dir: UploadTransfer, file: "f", start: 100, total: 1100,
+                    started: start, sampled: start}
+
+  /* until there's been a full interval the average is all there is */
+  xfer.count = 50
+  s := xfer.snapshot("foo", at(500))
+  if s.Nick != "foo" || s.File != "f" || s.Direction != UploadTransfer {
+    t.Error(s)
+  }
+  if s.Done != 150 || s.Total != 1100 { t.Error(s.Done, s.Total) }
+  if s.Average != 100 || s.Speed != 100 { t.Error(s.Average, s.Speed) }
+  if s.ETA != 9500 * time.Millisecond { t.Error(s.ETA) }
+
+  xfer.count = 250
+  s = xfer.snapshot("foo", at(2000))
+  if s.Speed != 125 || s.Average != 125 { t.Error(s.Speed, s.Average) }
+
+  /* the current speed sticks around for the rest of the interval */
+  xfer.count = 300
+  s = xfer.snapshot("foo", at(2500))
+  if s.Speed != 125 || s.Average != 120 { t.Error(s.Speed, s.Average) }
+  xfer.count = 550
+  s = xfer.snapshot("foo", at(3000))
+  if s.Speed != 300 { t.Error(s.Speed) }
+
+  /* nothing moving means no idea when it'll be done */
+  s = xfer.snapshot("foo", at(4000))
+  if s.Speed != 0 || s.ETA != 0 { t.Error(s.Speed, s.ETA) }
+}
+
+func Test_TransfersInFlight(t *testing.T) {
+  var m method
+  c, in, out, _in, _out := setupPeer(t)
+  defer teardownPeer(t, c, _in, _out)
+  handshake(t, in, out, "ADCGet")
+
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4})
+  dl.done = make(chan error, 1)
+  go c.download(dl)
+  getcmd(t, in, "ADCGET", &m)
+  if string(m.data) != "file f 0 4" { t.Fatal(string(m.data)) }
+  xsend(t, out, "$ADCSND file f 0 4|ab")
+
+  var transfers []Transfer
+  for i := 0; i < 200; i++ {
+    transfers = c.Transfers()
+    if len(transfers) == 1 && transfers[0].Done == 2 { break }
+    time.Sleep(10 * time.Millisecond)
+  }
+  if len(transfers) != 1 { t.Fatal(transfers) }
+  s := transfers[0]
+  if s.Direction != DownloadTransfer || s.Nick != "bar" || s.File != "f" ||
+     s.Done != 2 || s.Total != 4 {
+    t.Error(s)
+  }
+
+  xsend(t, out, "cd")
+  if err := <-dl.done; err != nil { t.Fatal(err) }
+  if transfers := c.Transfers(); len(transfers) != 0 { t.Error(transfers) }
+}
@@ -0,0 +1,143 @@
+package tth
+
+/* An implementation of the Tiger hash function based on the article by Ross
+ * Anderson and Eli Biham "Tiger: A Fast New Hash Function". This follows the
+ * original (not Tiger2) padding scheme, which is what TTH is defined over. */
+
+import "encoding/binary"
+import "hash"
+
+const TigerSize = 24
+const TigerBlockSize = 64
+
+type tiger struct {
+  state  [3]uint64
+  buf    [TigerBlockSize]byte
+  nbuf   int
+  length uint64
+}
+
+func NewTiger() hash.Hash {
+  t := &tiger{}
+  t.Reset()
+  return t
+}
+
+func (t *tiger) Reset() {
+  t.state[0] = 0x0123456789ABCDEF
+  t.state[1] = 0xFEDCBA9876543210
+  t.state[2] = 0xF096A5B4C3B2E187
+  t.nbuf = 0
+  t.length = 0
+}
+
+func (t *tiger) Size() int { return TigerSize }
+
+func (t *tiger) BlockSize() int { return TigerBlockSize }
+
+func (t *tiger) Write(p []byte) (int, error) {
+  n := len(p)
+  t.length += uint64(n)
+
+  /* Try to fill a partial block first */
+  if t.nbuf > 0 {
+    c := copy(t.buf[t.nbuf:], p)
+    t.nbuf += c
+    p = p[c:]
+    if t.nbuf < TigerBlockSize {
+      return n, nil
+    }
+    t.block(t.buf[:])
+    t.nbuf = 0
+  }
+  for len(p) >= TigerBlockSize {
+    t.block(p[:TigerBlockSize])
+    p = p[TigerBlockSize:]
+  }
+  t.nbuf = copy(t.buf[:], p)
+  return n, nil
+}
+
+func (t *tiger) Sum(in []byte) []byte {
+  /* Don't modify the receiver, the caller may want to keep writing */
+  d := *t
+  length := d.length << 3
+
+  var pad [TigerBlockSize + 8]byte
+  pad[0] = 0x01
+  if d.nbuf < 56 {
+    d.Write(pad[0 : 56-d.nbuf])
+  } else {
+    d.Write(pad[0 : 64+56-d.nbuf])
+  }
+  binary.LittleEndian.PutUint64(pad[:8], length)
+  d.Write(pad[:8])
+  if d.nbuf != 0 {
+    panic("tiger: bad padding")
+  }
+
+  var digest [TigerSize]byte
+  binary.LittleEndian.PutUint64(digest[0:], d.state[0])
+  binary.LittleEndian.PutUint64(digest[8:], d.state[1])
+  binary.LittleEndian.PutUint64(digest[16:], d.state[2])
+  return append(in, digest[:]...)
+}
+
+func round(a, b, c *uint64, x, mul uint64) {
+  *c ^= x
+  cc := *c
+  *a -= sboxes[0][byte(cc)] ^ sboxes[1][byte(cc>>16)] ^
+        sboxes[2][byte(cc>>32)] ^ sboxes[3][byte(cc>>48)]
+  *b += sboxes[3][byte(cc>>8)] ^ sboxes[2][byte(cc>>24)] ^
+        sboxes[1][byte(cc>>40)] ^ sboxes[0][byte(cc>>56)]
+  *b *= mul
+}
+
+func pass(a, b, c *uint64, x *[8]uint64, mul uint64) {
+  round(a, b, c, x[0], mul)
+  round(b, c, a, x[1], mul)
+  round(c, a, b, x[2], mul)
+  round(a, b, c, x[3], mul)
+  round(b, c, a, x[4], mul)
+  round(c, a, b, x[5], mul)
+  round(a, b, c, x[6], mul)
+  round(b, c, a, x[7], mul)
+}
+
+func schedule(x *[8]uint64) {
+  x[0] -= x[7] ^ 0xA5A5A5A5A5A5A5A5
+  x[1] ^= x[0]
+  x[2] += x[1]
+  x[3] -= x[2] ^ ((^x[1]) << 19)
+  x[4] ^= x[3]
+  x[5] += x[4]
+  x[6] -= x[5] ^ ((^x[4]) >> 23)
+  x[7] ^= x[6]
+  x[0] += x[7]
+  x[1] -= x[0] ^ ((^x[7]) << 19)
+  x[2] ^= x[1]
+  x[3] += x[2]
+  x[4] -= x[3] ^ ((^x[2]) >> 23)
+  x[5] ^= x[4]
+  x[6] += x[5]
+  x[7] -= x[6] ^ 0x0123456789ABCDEF
+}
+
+func (t *tiger) block(p []byte) {
+  var x [8]uint64
+  for i := range x {
+    x[i] = binary.LittleEndian.Uint64(p[i*8:])
+  }
+  a, b, c := t.state[0], t.state[1], t.state[2]
+
+  pass(&a, &b, &c, &x, 5)
+  schedule(&x)
+  pass(&c, &a, &b, &x, 7)
+  schedule(&x)
+  pass(&b, &c, &a, &x, 9)
+
+  /* feedforward operation */
+  t.state[0] ^= a
+  t.state[1] = b - t.state[1]
+  t.state[2] += c
+}
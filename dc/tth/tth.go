@@ -1,27 +1,253 @@
 package tth
 
-// #cgo linux LDFLAGS: -lgcc_s
-// #include <stdint.h>
-// #include <stdlib.h>
-// extern char *fargo_tth(int fd, uint64_t size, void *progress);
-import "C"
+/* The Tiger Tree Hash is a merkle tree built on top of the tiger hash function.
+ * The input is split into 1024-byte leaves which are hashed with a 0x00
+ * prefix, and then pairs of nodes are combined by hashing their concatenation
+ * with a 0x01 prefix. The tree is built incrementally with a stack of partial
+ * subtrees, so only O(log n) memory is needed regardless of the input size. */
 
 import "encoding/base32"
 import "errors"
-import "os"
-import "unsafe"
+import "hash"
+import "io"
 
-func Hash(f *os.File, size uint64, progress *uint64) (string, error) {
-  ret := C.fargo_tth(C.int(f.Fd()), C.uint64_t(size), unsafe.Pointer(progress))
-  if ret == nil {
-    return "", errors.New("error calculating TTH")
+const Size = TigerSize
+const BlockSize = 1024
+
+/* Invoked with the number of bytes which were just read while hashing */
+type ProgressFunc func(n int)
+
+type Tree struct {
+  leaf    tiger
+  leafLen int
+  leaves  uint64
+  stack   [64][Size]byte
+
+  /* nodes at this height are kept, they're the leaves of a coarser tree */
+  keep    bool
+  height  int
+  nodes   []byte
+}
+
+var SizeMismatch = errors.New("file size changed while hashing")
+var InvalidLeaves = errors.New("invalid tree leaves")
+var InvalidHash = errors.New("invalid TTH")
+
+func New() hash.Hash {
+  t := &Tree{}
+  t.Reset()
+  return t
+}
+
+/* Creates a tree which also keeps the hash of every blockSize bytes of input,
+ * where blockSize is a power of two multiple of the leaf size */
+func NewWithLeaves(blockSize uint64) *Tree {
+  t := &Tree{keep: true}
+  for b := uint64(BlockSize); b < blockSize; b <<= 1 {
+    t.height++
   }
-  data := []byte(C.GoString(ret))
-  C.free(unsafe.Pointer(ret))
+  t.Reset()
+  return t
+}
+
+func (t *Tree) Reset() {
+  t.leaves = 0
+  t.nodes = t.nodes[:0]
+  t.newLeaf()
+}
 
-  hash := base32.StdEncoding.EncodeToString(data)
-  if hash[len(hash) - 1] == '=' {
+func (t *Tree) Size() int { return Size }
+
+func (t *Tree) BlockSize() int { return BlockSize }
+
+func (t *Tree) Write(p []byte) (int, error) {
+  n := len(p)
+  for len(p) > 0 {
+    amt := BlockSize - t.leafLen
+    if amt > len(p) {
+      amt = len(p)
+    }
+    t.leaf.Write(p[:amt])
+    t.leafLen += amt
+    p = p[amt:]
+    if t.leafLen == BlockSize {
+      t.finishLeaf()
+    }
+  }
+  return n, nil
+}
+
+func (t *Tree) Sum(in []byte) []byte {
+  /* Work on a copy so more data can be written afterwards */
+  d := *t
+  if d.leaves == 0 || d.leafLen > 0 {
+    d.finishLeaf()
+  }
+
+  /* Fold everything on the stack, starting with the smallest subtree */
+  pos := 0
+  for d.leaves & (1 << uint(pos)) == 0 {
+    pos++
+  }
+  root := d.stack[pos]
+  for pos++; pos < len(d.stack); pos++ {
+    if d.leaves & (1 << uint(pos)) != 0 {
+      root = combine(&d.stack[pos], &root)
+    }
+  }
+  return append(in, root[:]...)
+}
+
+/* Returns the kept hashes for everything written so far, with the last one
+ * covering whatever is left over at the end */
+func (t *Tree) Leaves() []byte {
+  d := *t
+  d.nodes = append([]byte(nil), t.nodes...)
+  if d.leaves == 0 || d.leafLen > 0 {
+    d.finishLeaf()
+  }
+
+  /* Fold the subtrees which are too small to have been kept */
+  pos := 0
+  for pos < d.height && d.leaves & (1 << uint(pos)) == 0 {
+    pos++
+  }
+  if pos == d.height { return d.nodes }
+  node := d.stack[pos]
+  for pos++; pos < d.height; pos++ {
+    if d.leaves & (1 << uint(pos)) != 0 {
+      node = combine(&d.stack[pos], &node)
+    }
+  }
+  return append(d.nodes, node[:]...)
+}
+
+func (t *Tree) newLeaf() {
+  t.leaf.Reset()
+  t.leaf.Write([]byte{0})
+  t.leafLen = 0
+}
+
+func (t *Tree) finishLeaf() {
+  var node [Size]byte
+  t.leaf.Sum(node[:0])
+  t.newLeaf()
+
+  /* The number of leaves tells us which subtrees are complete and need to be
+   * combined with this new node (idea borrowed from RHash) */
+  pos := 0
+  for it := uint64(1); ; it <<= 1 {
+    if t.keep && pos == t.height {
+      t.nodes = append(t.nodes, node[:]...)
+    }
+    if it & t.leaves == 0 { break }
+    node = combine(&t.stack[pos], &node)
+    pos++
+  }
+  t.stack[pos] = node
+  t.leaves++
+}
+
+/* Calculates the root of a tree from one of its levels */
+func Root(leaves []byte) ([]byte, error) {
+  if len(leaves) == 0 || len(leaves) % Size != 0 {
+    return nil, InvalidLeaves
+  }
+  level := make([][Size]byte, len(leaves) / Size)
+  for i := range level {
+    copy(level[i][:], leaves[i * Size:])
+  }
+  for len(level) > 1 {
+    next := level[:0]
+    for i := 0; i < len(level); i += 2 {
+      if i + 1 < len(level) {
+        next = append(next, combine(&level[i], &level[i + 1]))
+      } else {
+        next = append(next, level[i])
+      }
+    }
+    level = next
+  }
+  return level[0][:], nil
+}
+
+/* The size of the blocks which leaves are kept for so that a file of the given
+ * size has no more than maxLeaves of them */
+func LeafBlockSize(size uint64, maxLeaves int) uint64 {
+  block := uint64(BlockSize)
+  for maxLeaves > 0 && (size + block - 1) / block > uint64(maxLeaves) {
+    block <<= 1
+  }
+  return block
+}
+
+func combine(left, right *[Size]byte) (ret [Size]byte) {
+  var h tiger
+  h.Reset()
+  h.Write([]byte{1})
+  h.Write(left[:])
+  h.Write(right[:])
+  h.Sum(ret[:0])
+  return
+}
+
+/* Encodes a TTH root in the base32 form used throughout the DC protocol */
+func Encode(sum []byte) string {
+  hash := base32.StdEncoding.EncodeToString(sum)
+  for len(hash) > 0 && hash[len(hash) - 1] == '=' {
     hash = hash[:len(hash) - 1]
   }
-  return hash, nil
+  return hash
+}
+
+/* The inverse of Encode, failing for anything which isn't a TTH */
+func Decode(hash string) ([]byte, error) {
+  sum, err := base32.StdEncoding.DecodeString(hash + "=")
+  if err != nil || len(sum) != Size { return nil, InvalidHash }
+  return sum, nil
+}
+
+/* Calculates the TTH of the contents of a reader which is expected to contain
+ * exactly size bytes. If the progress function is non-nil, it is invoked as
+ * data is consumed. */
+func Hash(r io.Reader, size uint64, progress ProgressFunc) (string, error) {
+  t := New()
+  err := consume(t, r, size, progress)
+  if err != nil { return "", err }
+  return Encode(t.Sum(nil)), nil
+}
+
+/* Like Hash, but also returns leaves of the tree, with no more than maxLeaves
+ * of them */
+func HashLeaves(r io.Reader, size uint64, maxLeaves int,
+                progress ProgressFunc) (string, []byte, error) {
+  t := NewWithLeaves(LeafBlockSize(size, maxLeaves))
+  err := consume(t, r, size, progress)
+  if err != nil { return "", nil, err }
+  return Encode(t.Sum(nil)), t.Leaves(), nil
+}
+
+func consume(t hash.Hash, r io.Reader, size uint64,
+             progress ProgressFunc) error {
+  buf := make([]byte, 64 * 1024)
+  rd := uint64(0)
+  for {
+    n, err := r.Read(buf)
+    if n > 0 {
+      rd += uint64(n)
+      /* file has been modified, time to back out */
+      if rd > size { return SizeMismatch }
+      t.Write(buf[:n])
+      if progress != nil {
+        progress(n)
+      }
+    }
+    if err == io.EOF {
+      break
+    } else if err != nil {
+      return err
+    }
+  }
+  if rd != size { return SizeMismatch }
+  return nil
 }
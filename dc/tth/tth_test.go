@@ -1,11 +1,14 @@
 package tth
 
 import "testing"
+import "encoding/hex"
 import "os"
 import "io"
 import "io/ioutil"
+import "strings"
+import "bytes"
 
-func hash(t *testing.T, data string) string {
+func hashFile(t *testing.T, data string) string {
   file, err := ioutil.TempFile(os.TempDir(), "fargo")
   if err != nil { t.Fatal(err) }
   defer file.Close()
@@ -22,8 +25,105 @@ func hash(t *testing.T, data string) string {
 }
 
 func Test_TTH(t *testing.T) {
-  h := hash(t, "foo\n")
+  h := hashFile(t, "foo\n")
   if h != "A2MPPCGS5CPJV6AOAP37ICDCFV3WYU7PBREC6FY" { t.Error(h) }
-  h = hash(t, "")
+  h = hashFile(t, "")
   if h != "LWPNACQDBZRYXW3VHJVCJ64QBZNGHOHHHZWCLNQ" { t.Error(h) }
 }
+
+func Test_TTHMultipleLeaves(t *testing.T) {
+  h := hashFile(t, strings.Repeat("a", 1025))
+  if h != "CDYY2OW6F6DTGCH3Q6NMSDLSRV7PNMAL3CED3DA" { t.Error(h) }
+  h = hashFile(t, strings.Repeat("a", 100000))
+  if h != "WJYXXZ3KYQB2MQSOE42HLXJ5XE6OGXKFIDYLOQQ" { t.Error(h) }
+}
+
+func Test_TTHStreaming(t *testing.T) {
+  data := []byte(strings.Repeat("abcdefg", 1000))
+  h := New()
+  for i := 0; i < len(data); i += 333 {
+    end := i + 333
+    if end > len(data) { end = len(data) }
+    h.Write(data[i:end])
+  }
+  whole := New()
+  whole.Write(data)
+  if Encode(h.Sum(nil)) != Encode(whole.Sum(nil)) { t.Error() }
+
+  /* Sum doesn't modify the state of the hash */
+  before := Encode(h.Sum(nil))
+  if Encode(h.Sum(nil)) != before { t.Error() }
+  h.Reset()
+  if Encode(h.Sum(nil)) != "LWPNACQDBZRYXW3VHJVCJ64QBZNGHOHHHZWCLNQ" {
+    t.Error(Encode(h.Sum(nil)))
+  }
+}
+
+func Test_HashProgress(t *testing.T) {
+  data := strings.Repeat("a", 200000)
+  total := 0
+  h, err := Hash(strings.NewReader(data), uint64(len(data)), func(n int) {
+    total += n
+  })
+  if err != nil { t.Fatal(err) }
+  if total != len(data) { t.Error(total) }
+  if h != hashFile(t, data) { t.Error(h) }
+}
+
+func Test_HashSizeMismatch(t *testing.T) {
+  _, err := Hash(strings.NewReader("abcd"), 3, nil)
+  if err != SizeMismatch { t.Error(err) }
+  _, err = Hash(strings.NewReader("abcd"), 5, nil)
+  if err != SizeMismatch { t.Error(err) }
+}
+
+func Test_Tiger(t *testing.T) {
+  h := NewTiger()
+  sum := hex.EncodeToString(h.Sum(nil))
+  if sum != "3293ac630c13f0245f92bbb1766e16167a4e58492dde73f3" { t.Error(sum) }
+  h.Write([]byte("abc"))
+  sum = hex.EncodeToString(h.Sum(nil))
+  if sum != "2aab1484e8c158f2bfb8c5ff41b57a525129131c957b5f93" { t.Error(sum) }
+}
+
+func Test_Leaves(t *testing.T) {
+  data := []byte(strings.Repeat("abcdefghij", 1000))
+  for _, size := range []int{0, 1, 1024, 1025, 4096, 5000, 10000} {
+    for _, block := range []uint64{1024, 2048, 8192} {
+      tree := NewWithLeaves(block)
+      tree.Write(data[:size])
+      leaves := tree.Leaves()
+      count := (uint64(size) + block - 1) / block
+      if count == 0 { count = 1 }
+      if uint64(len(leaves)) != count * Size {
+        t.Errorf("%d/%d: %d leaves", size, block, len(leaves) / Size)
+        continue
+      }
+
+      /* each leaf is the root of the tree of its block */
+      for i := uint64(0); i < count; i++ {
+        end := (i + 1) * block
+        if end > uint64(size) { end = uint64(size) }
+        sub := New()
+        sub.Write(data[i * block:end])
+        if !bytes.Equal(sub.Sum(nil), leaves[i * Size:(i + 1) * Size]) {
+          t.Errorf("%d/%d: leaf %d", size, block, i)
+        }
+      }
+      root, err := Root(leaves)
+      if err != nil { t.Fatal(err) }
+      if !bytes.Equal(root, tree.Sum(nil)) { t.Errorf("%d/%d root", size, block) }
+    }
+  }
+  if _, err := Root(make([]byte, 5)); err != InvalidLeaves { t.Error(err) }
+}
+
+func Test_HashLeaves(t *testing.T) {
+  data := strings.Repeat("a", 100000)
+  if LeafBlockSize(100000, 10) != 16384 { t.Error(LeafBlockSize(100000, 10)) }
+  if LeafBlockSize(100, 10) != BlockSize { t.Error() }
+  h, leaves, err := HashLeaves(strings.NewReader(data), 100000, 10, nil)
+  if err != nil { t.Fatal(err) }
+  if h != hashFile(t, data) { t.Error(h) }
+  if len(leaves) != 7 * Size { t.Error(len(leaves)) }
+}
@@ -0,0 +1,207 @@
+package dc
+
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+
+import "github.com/alexcrichton/fargo/dc/tth"
+
+/* Downloads which have the leaves of their hash tree are checked a block at a
+ * time as they arrive. Blocks which don't match are asked for again, and a
+ * peer which sends corrupt data too many times is given up on. Whatever the
+ * leaves, the whole file is hashed again once it's complete. */
+
+var CorruptLimit = 3
+var CorruptData = errors.New("peer keeps sending corrupt data")
+
+/* Leaves are only used if there are at most MaxLeaves of them and their blocks
+ * are no larger than MaxLeafBlock. Anything coarser isn't worth checking a
+ * block at a time, and the file is just hashed once it's complete. */
+var MaxLeaves = 1 << 16
+var MaxLeafBlock int64 = 64 << 20
+var CoarseLeaves = errors.New("leaves are too coarse to check blocks with")
+
+/* How much of a block is read at once while checking it */
+const verifyChunk = 64 << 10
+
+/* Whether the leaves of the download's tree should be asked for first */
+func (d *download) wantLeaves(p *peer) bool {
+  return d.tth != "" && d.size >= 0 && d.leaves == nil && !d.unverified &&
+         p.implements("ADCGet") && p.implements("TTHL")
+}
+
+/* Checks how much leaf data a peer says it's sending before any of it is read
+ * in. There can't be more than one leaf for each of the smallest blocks. */
+func (d *download) checkLeafSize(size int64) error {
+  most := d.length() / tth.BlockSize + 1
+  if most > int64(MaxLeaves) {
+    most = int64(MaxLeaves)
+  }
+  if size < 0 || size % tth.Size != 0 || size > most * tth.Size {
+    return tth.InvalidLeaves
+  }
+  return nil
+}
+
+/* Accepts leaves received from a peer, provided they're consistent with the
+ * TTH and size of the file */
+func (d *download) setLeaves(leaves []byte) error {
+  sum, err := tth.Root(leaves)
+  if err != nil { return err }
+  if tth.Encode(sum) != d.tth { return tth.InvalidLeaves }
+  count := int64(len(leaves) / tth.Size)
+  block := int64(tth.LeafBlockSize(uint64(d.length()), int(count)))
+  if d.blocks(block) != count { return tth.InvalidLeaves }
+  if block > MaxLeafBlock { return CoarseLeaves }
+  d.leaves = leaves
+  d.block = block
+  return nil
+}
+
+func (d *download) blocks(block int64) int64 {
+  if d.length() == 0 { return 1 }
+  return (d.length() + block - 1) / block
+}
+
+/* The range of the file covered by a block */
+func (d *download) segment(i int64) (int64, int64) {
+  offset := i * d.block
+  size := d.block
+  if offset + size > d.length() {
+    size = d.length() - offset
+  }
+  return offset, size
+}
+
+/* Checks every block which was entirely written by a transfer of the given
+ * range, returning those which don't match their leaf. Blocks are read a bit
+ * at a time, since they can be large. */
+func (d *download) verify(f *os.File, offset, size int64) ([]int64, error) {
+  bad := make([]int64, 0)
+  buf := make([]byte, verifyChunk)
+  for i := (offset + d.block - 1) / d.block; i < d.blocks(d.block); i++ {
+    start, n := d.segment(i)
+    if start + n > offset + size { break }
+    tree := tth.New()
+    _, err := io.CopyBuffer(tree, io.NewSectionReader(f, start, n), buf)
+    if err != nil { return nil, err }
+    if !bytes.Equal(tree.Sum(nil), d.leaves[i * tth.Size:(i + 1) * tth.Size]) {
+      bad = append(bad, i)
+    }
+  }
+  return bad, nil
+}
+
+/* Updates which blocks still need repairing after a transfer of the given
+ * range, returning how many of the blocks it covered were corrupt */
+func (d *download) check(f *os.File, offset, size int64) (int, error) {
+  bad, err := d.verify(f, offset, size)
+  if err != nil { return 0, err }
+  left := d.bad[:0]
+  for _, i := range d.bad {
+    start, n := d.segment(i)
+    if start < offset || start + n > offset + size {
+      left = append(left, i)
+    }
+  }
+  d.bad = append(left, bad...)
+  return len(bad), nil
+}
+
+/* Forgets everything downloaded so far, once it's been thrown away */
+func (d *download) restart() {
+  d.size = d.total()
+  d.offset = 0
+  d.bad = nil
+  d.corrupt = 0
+  d.parts = nil
+}
+
+/* Whether all of a download has been fetched already, so that it only needs
+ * to be checked and moved into place. That's left undone if the client stops
+ * while it's being hashed, or if moving it into place failed. */
+func (d *download) fetchedAll() bool {
+  if d.tth == "" || d.size < 0 || d.fileList() || d.dst == "" { return false }
+  if d.fetched() != d.total() { return false }
+  info, err := os.Stat(d.temp())
+  return err == nil && info.Size() == d.total()
+}
+
+/* Hands a finished download over to be hashed. It's kept track of meanwhile
+ * so that it's still saved with the queue. The client must be locked. */
+func (c *Client) startVerify(dl *download) {
+  c.verifying = append(c.verifying, dl)
+  go c.verifyDownload(dl)
+}
+
+/* Returns whether the download was still being hashed, it isn't if it was
+ * canceled in the meantime. The client must be locked. */
+func (c *Client) stopVerify(dl *download) bool {
+  for i, d := range c.verifying {
+    if d == dl {
+      c.verifying = append(c.verifying[:i], c.verifying[i+1:]...)
+      return true
+    }
+  }
+  return false
+}
+
+/* Hashes a finished download on the hashing workers, only reporting it done
+ * if it matches its TTH and it could be moved into place. Otherwise the file
+ * is moved aside and the download is queued up again. One which is canceled
+ * while it's hashed is left alone, or deleted if it's to go too. */
+func (c *Client) verifyDownload(dl *download) {
+  defer c.saveQueue()
+  path := dl.temp()
+  ok := c.shares.hashFile(path, ByteSize(dl.total())) == dl.tth
+  c.Lock()
+  if !c.stopVerify(dl) {
+    c.Unlock()
+    if dl.purge {
+      os.Remove(path)
+    }
+    return
+  }
+  if ok {
+    err := dl.commit(c.DownloadRoot)
+    if err != nil {
+      /* all that's left when it's retried is to move it into place */
+      c.log("couldn't finish downloading " + dl.file + ": " + err.Error())
+      dl.resumeFrom(path)
+      c.fail(dl, err)
+      c.Unlock()
+      return
+    }
+    c.Unlock()
+    c.log("Finished downloading: " + dl.file)
+    dl.finish(nil)
+    return
+  }
+  c.Unlock()
+
+  aside := dl.dst + ".corrupt"
+  err := os.Rename(path, aside)
+  if err != nil {
+    c.log("couldn't move aside corrupt download: " + err.Error())
+    aside = path
+  }
+  dl.attempts++
+  dl.restart()
+  if dl.attempts >= CorruptLimit {
+    c.log(fmt.Sprintf("corrupt download of %s from %s kept in %s, giving up",
+                      dl.file, dl.nick, aside))
+    dl.attempts = 0
+    c.Lock()
+    c.fail(dl, CorruptData)
+    c.Unlock()
+    return
+  }
+  c.log(fmt.Sprintf("corrupt download of %s from %s moved to %s, " +
+                    "downloading it again", dl.file, dl.nick, aside))
+  err = c.download(dl)
+  if err != nil {
+    c.log("couldn't download " + dl.file + " again: " + err.Error())
+  }
+}
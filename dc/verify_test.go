@@ -0,0 +1,206 @@
+package dc
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+import "github.com/alexcrichton/fargo/dc/tth"
+
+func leavesOf(t *testing.T, data string, max int) (string, []byte) {
+  root, leaves, err := tth.HashLeaves(strings.NewReader(data),
+                                      uint64(len(data)), max, nil)
+  if err != nil { t.Fatal(err) }
+  return root, leaves
+}
+
+func Test_SetLeaves(t *testing.T) {
+  data := strings.Repeat("0123456789", 500)
+  root, leaves := leavesOf(t, data, 3)
+  dl := &download{tth: root, size: int64(len(data))}
+  if err := dl.setLeaves(leaves); err != nil { t.Fatal(err) }
+  if dl.block != 2048 { t.Error(dl.block) }
+
+  /* leaves have to add up to the root, and match the size of the file */
+  dl = &download{tth: root, size: int64(len(data))}
+  bad := append([]byte(nil), leaves...)
+  bad[0]++
+  if dl.setLeaves(bad) == nil { t.Error() }
+  if dl.setLeaves(leaves[:5]) == nil { t.Error() }
+  dl.size = 100000
+  if dl.setLeaves(leaves) == nil { t.Error() }
+  if dl.leaves != nil { t.Error() }
+
+  /* blocks which are too large aren't worth checking on their own */
+  defer func(block int64) { MaxLeafBlock = block }(MaxLeafBlock)
+  MaxLeafBlock = 1024
+  dl = &download{tth: root, size: int64(len(data))}
+  if err := dl.setLeaves(leaves); err != CoarseLeaves { t.Error(err) }
+  rootOnly, _ := tth.Decode(root)
+  if err := dl.setLeaves(rootOnly); err != CoarseLeaves { t.Error(err) }
+  if dl.leaves != nil { t.Error() }
+}
+
+/* Leaf data is checked for a sensible size before it's read in */
+func Test_CheckLeafSize(t *testing.T) {
+  dl := &download{size: 5000}
+  for _, size := range []int64{0, 24, 5 * 24} {
+    if err := dl.checkLeafSize(size); err != nil { t.Error(size, err) }
+  }
+  for _, size := range []int64{-24, 25, 6 * 24, 1 << 40} {
+    if dl.checkLeafSize(size) == nil { t.Error(size) }
+  }
+  defer func(max int) { MaxLeaves = max }(MaxLeaves)
+  MaxLeaves = 2
+  if dl.checkLeafSize(3 * 24) == nil { t.Error() }
+}
+
+func Test_VerifyBlocks(t *testing.T) {
+  data := strings.Repeat("0123456789", 500)
+  root, leaves := leavesOf(t, data, 0)
+  dl := &download{tth: root, size: int64(len(data))}
+  if err := dl.setLeaves(leaves); err != nil { t.Fatal(err) }
+
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  corrupt := []byte(data)
+  corrupt[1500] = 'x'
+  corrupt[4999] = 'x'
+  err := ioutil.WriteFile(wd + "/f", corrupt, os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  f, err := os.OpenFile(wd + "/f", os.O_RDWR, 0)
+  if err != nil { t.Fatal(err) }
+  defer f.Close()
+
+  n, err := dl.check(f, 0, int64(len(data)))
+  if err != nil || n != 2 { t.Fatal(n, err) }
+  if len(dl.bad) != 2 || dl.bad[0] != 1 || dl.bad[1] != 4 { t.Fatal(dl.bad) }
+  if offset, size := dl.segment(4); offset != 4096 || size != 904 {
+    t.Error(offset, size)
+  }
+
+  /* repairing one block leaves the other */
+  _, err = f.WriteAt([]byte(data[1024:2048]), 1024)
+  if err != nil { t.Fatal(err) }
+  n, err = dl.check(f, 1024, 1024)
+  if err != nil || n != 0 { t.Fatal(n, err) }
+  if len(dl.bad) != 1 || dl.bad[0] != 4 { t.Fatal(dl.bad) }
+
+  /* blocks only partly covered by a transfer aren't checked */
+  bad, err := dl.verify(f, 4000, 900)
+  if err != nil || len(bad) != 0 { t.Error(bad, err) }
+  bad, err = dl.verify(f, 1000, 4000)
+  if err != nil || len(bad) != 1 || bad[0] != 4 { t.Error(bad, err) }
+}
+
+/* Waits for a finished download to be moved into place */
+func waitFile(t *testing.T, path, data string) {
+  for i := 0; i < 200; i++ {
+    if got, err := ioutil.ReadFile(path); err == nil && string(got) == data {
+      return
+    }
+    time.Sleep(5 * time.Millisecond)
+  }
+  t.Fatal("never finished: ", path)
+}
+
+/* Downloads which were being hashed are saved with the queue and checked again
+ * once they're loaded, and moving them into place is retried if it fails */
+func Test_VerifyInterrupted(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  root := "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI" /* "abcd" */
+  client := func() *Client {
+    c := NewClient()
+    c.Quiet = true
+    c.CacheDir = wd
+    c.DownloadRoot = wd
+    return c
+  }
+
+  c := client()
+  dl := NewDownloadFile("bar", "/f", &File{Size: 4, TTH: root})
+  dl.dst = filepath.Join(wd, "f")
+  err := ioutil.WriteFile(dl.temp(), []byte("abcd"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  c.Lock()
+  c.identify(dl)
+  c.verifying = append(c.verifying, dl)
+  c.Unlock()
+  c.saveQueue()
+
+  c = client()
+  c.SpawnHashers()
+  defer c.shares.halt()
+  if err := c.LoadQueue(); err != nil { t.Fatal(err) }
+  waitFile(t, dl.dst, "abcd")
+
+  /* something in the way is retried rather than forgotten */
+  dl = NewDownloadFile("bar", "/g", &File{Size: 4, TTH: root})
+  dl.dst = filepath.Join(wd, "g")
+  dl.conflict = ConflictOverwrite
+  dl.done = make(chan error, 1)
+  err = ioutil.WriteFile(dl.temp(), []byte("abcd"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  if err := os.MkdirAll(filepath.Join(dl.dst, "x"), 0755); err != nil {
+    t.Fatal(err)
+  }
+  c.Lock()
+  c.identify(dl)
+  c.startVerify(dl)
+  c.Unlock()
+  if err := <-dl.done; err == nil { t.Fatal() }
+  queue := c.Queue()
+  if len(queue) != 1 || queue[0].State != Failed || queue[0].RetryAt.IsZero() {
+    t.Fatal(queue)
+  }
+  dst := dl.dst
+  if err := os.RemoveAll(dst); err != nil { t.Fatal(err) }
+  if err := c.RetryDownload(dl.id); err != nil { t.Fatal(err) }
+  waitFile(t, dst, "abcd")
+  if err := <-dl.done; err != nil { t.Error(err) }
+}
+
+/* Downloads being hashed can still be paused and canceled */
+func Test_CancelVerifying(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  root := "SQF2PFTVIFRR5KJSI45IDENXMB43NI7EIXYGHGI" /* "abcd" */
+  c := NewClient()
+  c.Quiet = true
+  c.CacheDir = wd
+  c.DownloadRoot = wd
+
+  /* nothing gets hashed until the hashers are around */
+  dls := make([]*download, 2)
+  for i, name := range []string{"f", "g"} {
+    dls[i] = NewDownloadFile("bar", "/" + name, &File{Size: 4, TTH: root})
+    dls[i].dst = filepath.Join(wd, name)
+    dls[i].done = make(chan error, 1)
+    err := ioutil.WriteFile(dls[i].temp(), []byte("abcd"), os.FileMode(0644))
+    if err != nil { t.Fatal(err) }
+    c.Lock()
+    c.identify(dls[i])
+    c.startVerify(dls[i])
+    c.Unlock()
+  }
+  if err := c.PauseDownload(dls[0].id); err != nil { t.Fatal(err) }
+  if err := c.CancelDownload(dls[1].id, true); err != nil { t.Fatal(err) }
+  if err := <-dls[1].done; err != Canceled { t.Error(err) }
+  queue := c.Queue()
+  if len(queue) != 1 || queue[0].ID != dls[0].id { t.Fatal(queue) }
+
+  /* the canceled one is cleaned up rather than moved into place */
+  dst, gone := dls[0].dst, dls[1].dst
+  c.SpawnHashers()
+  defer c.shares.halt()
+  waitFile(t, dst, "abcd")
+  for i := 0; i < 200; i++ {
+    if _, err := os.Stat(gone + TempSuffix); err != nil { break }
+    time.Sleep(5 * time.Millisecond)
+  }
+  if _, err := os.Stat(gone + TempSuffix); err == nil { t.Error("kept") }
+  if _, err := os.Stat(gone); err == nil { t.Error("committed") }
+}
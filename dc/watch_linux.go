@@ -0,0 +1,120 @@
+package dc
+
+/* Filesystem watching via inotify. Each directory of each share gets its own
+ * watch because inotify isn't recursive. Events are translated into the real
+ * path of whatever changed and handed over to the hashing goroutine, which is
+ * responsible for updating the file listing. */
+
+import "bytes"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+import "syscall"
+import "unsafe"
+
+type watcher struct {
+  fd      int
+  file    *os.File
+  changes chan string
+  done    chan int
+
+  sync.Mutex
+  paths   map[int32]string
+  wds     map[string]int32
+}
+
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE |
+                  syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+                  syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF |
+                  syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR
+
+func newWatcher(changes chan string) (*watcher, error) {
+  fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+  if err != nil { return nil, os.NewSyscallError("inotify_init1", err) }
+  /* Reads go through an os.File so that closing it wakes up the reader */
+  w := &watcher{fd:      fd,
+                file:    os.NewFile(uintptr(fd), "inotify"),
+                changes: changes,
+                done:    make(chan int),
+                paths:   make(map[int32]string),
+                wds:     make(map[string]int32)}
+  go w.run()
+  return w, nil
+}
+
+/* Starts watching the directory at the given path. Returns WatchLimit if the
+ * kernel won't give us any more watches */
+func (w *watcher) add(dir string) error {
+  w.Lock()
+  defer w.Unlock()
+  if _, ok := w.wds[dir]; ok { return nil }
+  wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+  if err == syscall.ENOSPC { return WatchLimit }
+  if err != nil { return os.NewSyscallError("inotify_add_watch", err) }
+  w.paths[int32(wd)] = dir
+  w.wds[dir] = int32(wd)
+  return nil
+}
+
+/* Stops watching the directory and everything underneath it. Watches belong
+ * to the directories themselves, so one which has since been picked up again
+ * under the name it was renamed to is left alone. */
+func (w *watcher) remove(dir string) {
+  w.Lock()
+  defer w.Unlock()
+  prefix := dir + string(filepath.Separator)
+  for path, wd := range w.wds {
+    if path != dir && !strings.HasPrefix(path, prefix) { continue }
+    delete(w.wds, path)
+    if w.paths[wd] == path {
+      syscall.InotifyRmWatch(w.fd, uint32(wd))
+      delete(w.paths, wd)
+    }
+  }
+}
+
+func (w *watcher) close() {
+  close(w.done)
+  w.file.Close()
+}
+
+func (w *watcher) run() {
+  buf := make([]byte, 64 * 1024)
+  for {
+    n, err := w.file.Read(buf)
+    if err != nil { return }
+
+    for i := 0; i + syscall.SizeofInotifyEvent <= n; {
+      ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+      name := buf[i + syscall.SizeofInotifyEvent :
+                  i + syscall.SizeofInotifyEvent + int(ev.Len)]
+      i += syscall.SizeofInotifyEvent + int(ev.Len)
+      if idx := bytes.IndexByte(name, 0); idx != -1 {
+        name = name[:idx]
+      }
+
+      /* An empty path means that events were lost and everything needs to be
+       * looked at again */
+      path := ""
+      if ev.Mask & syscall.IN_Q_OVERFLOW == 0 {
+        w.Lock()
+        dir, ok := w.paths[ev.Wd]
+        if ev.Mask & syscall.IN_IGNORED != 0 {
+          delete(w.paths, ev.Wd)
+          if w.wds[dir] == ev.Wd {
+            delete(w.wds, dir)
+          }
+        }
+        w.Unlock()
+        if !ok || ev.Mask & syscall.IN_IGNORED != 0 { continue }
+        path = filepath.Join(dir, string(name))
+      }
+
+      select {
+        case w.changes <- path:
+        case <-w.done: return
+      }
+    }
+  }
+}
@@ -0,0 +1,147 @@
+package dc
+
+import "testing"
+import "io/ioutil"
+import "os"
+import "time"
+
+/* Filesystem events are delivered asynchronously, so poll for a while */
+func eventually(t *testing.T, s *Shares, path string, present bool) {
+  for i := 0; i < 100; i++ {
+    if (s.queryWait(path) != nil) == present { return }
+    time.Sleep(20 * time.Millisecond)
+  }
+  t.Errorf("%s: expected present = %v", path, present)
+}
+
+func Test_WatchShares(t *testing.T) {
+  WatchDelay = 10 * time.Millisecond
+  shares, wd := setup(t)
+  defer teardown(shares, wd)
+  shares.add("name", "foo")
+  if shares.queryWait("name/bar/a") == nil { t.Fatal() }
+
+  /* creating a file. The files themselves are updated by the hashing
+   * goroutine as it goes, so they're looked up by TTH rather than having
+   * their fields read here. */
+  err := ioutil.WriteFile("foo/d", []byte("d"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  eventually(t, shares, "TTH/Z47SK36HQPN4L53EMWIYMF3E6LF46HJRCEQQQ4Y", true)
+  f := shares.query("TTH/Z47SK36HQPN4L53EMWIYMF3E6LF46HJRCEQQQ4Y")
+  if f == nil || f.path != "name/d" { t.Fatal(f) }
+
+  /* modifying a file rehashes it */
+  err = ioutil.WriteFile("foo/d", []byte("dd"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  eventually(t, shares, "TTH/FZH4TYZRKFCNCEVOUREOOKQ2U32LUPMSCGYXAMY", true)
+  f = shares.query("TTH/FZH4TYZRKFCNCEVOUREOOKQ2U32LUPMSCGYXAMY")
+  if f == nil || f.path != "name/d" { t.Error(f) }
+  eventually(t, shares, "TTH/Z47SK36HQPN4L53EMWIYMF3E6LF46HJRCEQQQ4Y", false)
+
+  /* deleting a file */
+  err = os.Remove("foo/d")
+  if err != nil { t.Fatal(err) }
+  eventually(t, shares, "name/d", false)
+
+  /* new directories get watched as well */
+  err = os.MkdirAll("foo/new/dir", os.FileMode(0755))
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("foo/new/dir/x", []byte("x"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  eventually(t, shares, "name/new/dir/x", true)
+
+  /* links made later to something already shared don't share it again */
+  err = os.Symlink("bar/a", "foo/la")
+  if err != nil { t.Fatal(err) }
+  err = os.Link("foo/bar/a", "foo/new/ha")
+  if err != nil { t.Fatal(err) }
+  err = ioutil.WriteFile("foo/new/y", []byte("y"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  eventually(t, shares, "name/new/y", true)
+  if shares.queryWait("name/la") != nil { t.Error("link shared") }
+  if shares.queryWait("name/new/ha") != nil { t.Error("hard link shared") }
+  if shares.queryWait("name/bar/a") == nil { t.Error("original gone") }
+
+  /* renaming a directory */
+  err = os.Rename("foo/bar", "foo/moved")
+  if err != nil { t.Fatal(err) }
+  eventually(t, shares, "name/moved/baz/a", true)
+  eventually(t, shares, "name/bar/a", false)
+
+  /* and what's in it is still watched afterwards, whichever order the old
+   * and new paths are looked at in */
+  old := "moved"
+  for _, dir := range []string{"one", "two", "three", "four"} {
+    err = os.Rename("foo/" + old, "foo/" + dir)
+    if err != nil { t.Fatal(err) }
+    eventually(t, shares, "name/" + dir + "/baz/a", true)
+    err = ioutil.WriteFile("foo/" + dir + "/baz/" + dir, []byte(dir),
+                           os.FileMode(0644))
+    if err != nil { t.Fatal(err) }
+    eventually(t, shares, "name/" + dir + "/baz/" + dir, true)
+    old = dir
+  }
+}
+
+/* A renamed directory keeps the same watch, so forgetting its old path mustn't
+ * stop its new one from being watched */
+func Test_WatchRename(t *testing.T) {
+  wd := tmpdir(t)
+  defer os.RemoveAll(wd)
+  err := os.Mkdir(wd + "/a", os.FileMode(0755))
+  if err != nil { t.Fatal(err) }
+  changes := make(chan string, 16)
+  w, err := newWatcher(changes)
+  if err != nil { t.Fatal(err) }
+  defer w.close()
+
+  if err = w.add(wd + "/a"); err != nil { t.Fatal(err) }
+  if err = os.Rename(wd + "/a", wd + "/b"); err != nil { t.Fatal(err) }
+  if err = w.add(wd + "/b"); err != nil { t.Fatal(err) }
+  w.remove(wd + "/a")
+  err = ioutil.WriteFile(wd + "/b/x", []byte("x"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  timeout := time.After(2 * time.Second)
+  for {
+    select {
+      case path := <-changes:
+        if path == wd + "/b/x" { return }
+      case <-timeout:
+        t.Fatal("no event for b/x")
+    }
+  }
+}
+
+/* Entries which come and go while being watched are counted as excluded the
+ * same as if the share had been scanned again */
+func Test_WatchExcluded(t *testing.T) {
+  WatchDelay = 10 * time.Millisecond
+  shares, wd := setup(t)
+  defer teardown(shares, wd)
+  shares.addWith("name", "foo", ShareFilter{HideDotfiles: true})
+  if shares.queryWait("name/bar/a") == nil { t.Fatal() }
+  excluded := func(want int) {
+    got := 0
+    for i := 0; i < 100; i++ {
+      shares.cmds <- getstats
+      got = (<-shares.stats).Shares[0].Excluded
+      if got == want { return }
+      time.Sleep(20 * time.Millisecond)
+    }
+    t.Errorf("%d excluded, expected %d", got, want)
+  }
+  excluded(0)
+
+  err := ioutil.WriteFile("foo/.x", []byte("x"), os.FileMode(0644))
+  if err != nil { t.Fatal(err) }
+  excluded(1)
+  err = os.MkdirAll("foo/new/.git", os.FileMode(0755))
+  if err != nil { t.Fatal(err) }
+  excluded(2)
+
+  /* and stop being counted once they're gone */
+  if err = os.Remove("foo/.x"); err != nil { t.Fatal(err) }
+  excluded(1)
+  if err = os.RemoveAll("foo/new"); err != nil { t.Fatal(err) }
+  excluded(0)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package dc
+
+import "errors"
+
+/* Filesystem watching is only implemented with inotify for now, everywhere
+ * else the shares are periodically rescanned instead */
+type watcher struct{}
+
+func newWatcher(changes chan string) (*watcher, error) {
+  return nil, errors.New("filesystem watching not supported")
+}
+
+func (w *watcher) add(dir string) error { return nil }
+func (w *watcher) remove(dir string)    {}
+func (w *watcher) close()               {}
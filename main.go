@@ -23,6 +23,12 @@ func main() {
 
   client.CacheDir = cache
   client.SpawnHashers()
+  if err := client.LoadRoutes(); err != nil {
+    println("Couldn't restore the download routes:", err.Error())
+  }
+  if err := client.LoadQueue(); err != nil {
+    println("Couldn't restore the download queue:", err.Error())
+  }
 
   file, err := os.Open(config)
   if err == nil {
@@ -41,6 +41,7 @@ package ui
 // extern char*(*fargo_completion_entry)(char*, int);
 import "C"
 
+import "errors"
 import "fmt"
 import "os"
 import "os/signal"
@@ -48,6 +49,7 @@ import "path"
 import "sort"
 import "strings"
 import "strconv"
+import "time"
 import "unsafe"
 
 import "github.com/alexcrichton/fargo/dc"
@@ -60,6 +62,8 @@ type Terminal struct {
   cwd  string
   prompt *C.char
   promptChange bool
+  watching bool /* whether transfers are being shown as they go */
+  watched  time.Time
 }
 
 var activeTerm *Terminal
@@ -67,9 +71,13 @@ var completionResults []string
 
 var commands = []string{"browse", "connect", "nicks", "ops", "help", "quit",
                         "ls", "pwd", "cd", "get", "share", "say", "status",
-                        "sharing"}
+                        "sharing", "priority", "queue", "cancel", "pause",
+                        "resume", "transfers", "failed", "retry"}
 var options = []string{"ulslots", "dlslots", "active", "passive", "hub",
-                       "nick", "download"}
+                       "nick", "download", "hidden", "exclude", "minsize",
+                       "maxsize", "allowext", "denyext", "symlinks",
+                       "hashers", "hashrate", "hashorder", "hashleaves",
+                       "conflict", "skipshared", "template", "route"}
 
 type NickList struct {
   Nicks  []string
@@ -165,7 +173,8 @@ func (t *Terminal) complete(cmd string, word string) []string {
       prep = part1 + "/"
     }
     /* Find all files within the last finished directory */
-    files, err := t.client.Listings(t.nick, t.resolve([]string{cmd, part1}))
+    files, err := t.client.CachedListings(t.nick,
+                                          t.resolve([]string{cmd, part1}))
     if files == nil || err != nil {
       break
     }
@@ -213,6 +222,7 @@ func (t *Terminal) resolve(parts []string) string {
 }
 
 func (t *Terminal) Exec(line string) {
+  t.watching = false
   line = strings.TrimSpace(line)
   idx := strings.Index(line, "#")
   if idx != -1 {
@@ -285,9 +295,46 @@ func (t *Terminal) Exec(line string) {
   case "get":
     if t.nick == "" {
       println("error: not browsing a nick")
+      break
+    }
+    var opts dc.DownloadOptions
+    if len(parts) > 1 {
+      rest, err := parseDownloadFlags(&opts, parts[1])
+      if err != nil {
+        t.err(err)
+        break
+      }
+      parts = parts[:1]
+      if rest != "" {
+        parts = append(parts, rest)
+      }
+    }
+    err := t.client.DownloadWith(t.nick, t.resolve(parts), opts)
+    if err != nil { t.err(err) }
+
+  case "priority":
+    args := []string{}
+    if len(parts) > 1 {
+      args = strings.SplitN(parts[1], " ", 2)
+    }
+    if t.nick == "" {
+      println("error: not browsing a nick")
+      break
+    } else if len(args) == 0 || args[0] == "" {
+      println("usage: priority <level> [path]")
+      break
+    }
+    priority, err := dc.ParsePriority(args[0])
+    if err != nil {
+      t.err(err)
+      break
+    }
+    pathname := t.resolve(append(parts[:1], args[1:]...))
+    n, err := t.client.SetPriority(t.nick, pathname, priority)
+    if err != nil {
+      t.err(err)
     } else {
-      err := t.client.Download(t.nick, t.resolve(parts))
-      if err != nil { t.err(err) }
+      fmt.Printf("%d downloads now %s\n", n, priority.String())
     }
 
   case "ls":
@@ -347,6 +394,25 @@ func (t *Terminal) Exec(line string) {
         case "active":    println("active address =", t.client.ClientAddress)
         case "ulslots":   println("upload slots =", t.client.UL.Cnt)
         case "dlslots":   println("download slots =", t.client.DL.Cnt)
+        case "conflict":  println("conflict =", t.client.Conflicts.String())
+        case "skipshared": println("skip shared =", t.client.SkipShared)
+        case "template":
+          template := t.client.Template
+          if template == "" {
+            template = dc.DefaultTemplate
+          }
+          println("template =", template)
+        case "route":
+          for _, r := range t.client.Routes() {
+            showRoute(&r)
+          }
+        case "hidden", "exclude", "minsize", "maxsize", "allowext",
+             "denyext", "symlinks":
+          filter := t.client.SharingStats().Filter
+          showFilter(parts[0], &filter)
+        case "hashers", "hashrate", "hashorder", "hashleaves":
+          settings := t.client.SharingStats().Hash
+          showHashSettings(parts[0], &settings)
       }
 
       break
@@ -368,6 +434,44 @@ func (t *Terminal) Exec(line string) {
           t.client.Passive = p
         }
 
+      case "conflict":
+        p, err := dc.ParseConflictPolicy(parts[1])
+        if err != nil {
+          t.err(err)
+        } else {
+          t.client.Conflicts = p
+        }
+
+      case "skipshared":
+        s, err := strconv.ParseBool(parts[1])
+        if err != nil {
+          t.err(err)
+        } else {
+          t.client.SkipShared = s
+        }
+
+      case "template":
+        err := t.client.SetTemplate(parts[1])
+        if err != nil { t.err(err) }
+
+      case "route":
+        args := strings.SplitN(parts[1], " ", 2)
+        var err error
+        if len(args) == 1 {
+          println("usage: set route <name> [-<rule> <value>...]|none")
+          break
+        } else if strings.TrimSpace(args[1]) == "none" {
+          err = t.client.RemoveRoute(args[0])
+        } else {
+          var r dc.Route
+          r, err = parseRouteFlags(args[1])
+          r.Name = args[0]
+          if err == nil {
+            err = t.client.SetRoute(r)
+          }
+        }
+        if err != nil { t.err(err) }
+
       case "ulslots", "dlslots":
         s, err := strconv.ParseInt(parts[1], 10, 32)
         if err != nil {
@@ -377,19 +481,42 @@ func (t *Terminal) Exec(line string) {
         } else {
           t.client.UL.Cnt = int(s)
         }
+
+      case "hidden", "exclude", "minsize", "maxsize", "allowext", "denyext",
+           "symlinks":
+        filter := t.client.SharingStats().Filter
+        err := setFilter(&filter, parts[0], parts[1])
+        if err != nil {
+          t.err(err)
+        } else {
+          t.client.SetShareFilter(filter)
+        }
+
+      case "hashers", "hashrate", "hashorder", "hashleaves":
+        settings := t.client.SharingStats().Hash
+        err := setHashSettings(&settings, parts[0], parts[1])
+        if err == nil {
+          err = t.client.SetHashSettings(settings)
+        }
+        if err != nil { t.err(err) }
     }
 
   case "share":
     if len(parts) == 1 {
-      println("usage: share <name> <directory>")
+      println("usage: share [-<rule> <value>...] <name> <directory>")
+      break
+    }
+    filter, rest, err := parseFilterFlags(parts[1])
+    if err != nil {
+      t.err(err)
       break
     }
-    parts := strings.SplitN(parts[1], " ", 2)
+    parts := strings.SplitN(rest, " ", 2)
     if len(parts) == 1 {
-      println("usage: share <name> <directory>")
+      println("usage: share [-<rule> <value>...] <name> <directory>")
       break
     }
-    err := t.client.Share(parts[0], parts[1])
+    err = t.client.ShareWith(parts[0], strings.TrimSpace(parts[1]), filter)
     if err != nil { t.err(err) }
   case "unshare":
     if len(parts) == 1 {
@@ -399,6 +526,86 @@ func (t *Terminal) Exec(line string) {
     err := t.client.Unshare(parts[1])
     if err != nil { t.err(err) }
 
+  case "queue":
+    for _, q := range t.client.Queue() {
+      size := "?"
+      if q.Size >= 0 {
+        size = dc.ByteSize(q.Size).String()
+      }
+      fmt.Printf("%4d %-7s %-8s %10s %10s %s:%s\n", q.ID, q.State.String(),
+                 q.Priority.String(), dc.ByteSize(q.Done).String(), size,
+                 q.Nick, q.File)
+      if len(q.Sources) > 0 {
+        fmt.Printf("     also from: %s\n", strings.Join(q.Sources, ", "))
+      }
+    }
+
+  case "failed":
+    for _, q := range t.client.Queue() {
+      if q.State != dc.Failed { continue }
+      retry := "given up"
+      if !q.RetryAt.IsZero() {
+        wait := time.Until(q.RetryAt) / time.Second * time.Second
+        retry = "retry in " + wait.String()
+      }
+      fmt.Printf("%4d %s:%s - %s (%d failures, %s)\n", q.ID, q.Nick, q.File,
+                 q.Reason, q.Failures, retry)
+    }
+
+  case "retry":
+    if len(parts) == 1 {
+      println("usage: retry <id>|all")
+      break
+    }
+    if parts[1] == "all" {
+      n, err := t.client.RetryAll()
+      if err != nil {
+        t.err(err)
+      } else {
+        fmt.Printf("retrying %d downloads\n", n)
+      }
+      break
+    }
+    id, err := strconv.ParseUint(parts[1], 10, 64)
+    if err == nil {
+      err = t.client.RetryDownload(id)
+    }
+    if err != nil { t.err(err) }
+
+  case "transfers":
+    if len(parts) > 1 && parts[1] == "-w" {
+      t.watching = true
+      t.watched = time.Now()
+    }
+    t.showTransfers()
+
+  case "cancel", "pause", "resume":
+    args := []string{}
+    if len(parts) > 1 {
+      args = strings.Fields(parts[1])
+    }
+    purge := len(args) > 0 && args[0] == "-delete" && parts[0] == "cancel"
+    if purge {
+      args = args[1:]
+    }
+    if len(args) != 1 {
+      if parts[0] == "cancel" {
+        println("usage: cancel [-delete] <id>")
+      } else {
+        println("usage: " + parts[0] + " <id>")
+      }
+      break
+    }
+    id, err := strconv.ParseUint(args[0], 10, 64)
+    if err == nil {
+      switch parts[0] {
+      case "cancel": err = t.client.CancelDownload(id, purge)
+      case "pause":  err = t.client.PauseDownload(id)
+      case "resume": err = t.client.ResumeDownload(id)
+      }
+    }
+    if err != nil { t.err(err) }
+
   case "status":
     print("Hub:  ")
     if t.client.HubAddress == "" {
@@ -434,8 +641,13 @@ func (t *Terminal) Exec(line string) {
     fmt.Printf("Sharing: %v\n", total)
 
     fmt.Printf("%40s  %10s    %s\n", "Path", "Name", "Size")
+    excluded := 0
     for _, share := range info.Shares {
       fmt.Printf("%40.40s %10s - %v\n", share.Dir, share.Name, share.Size)
+      excluded += share.Excluded
+    }
+    if excluded > 0 {
+      fmt.Printf("%d entries excluded by sharing rules\n", excluded)
     }
     if info.ToHash > 0 {
       fmt.Printf("%v left to hash over %d files\n", info.ToHashSize,
@@ -465,9 +677,32 @@ browsing:
   pwd             print the current directory
   cd [dir]        move into the specified directory, or with no argument go back
                   to the root directory
+  get [-conflict policy] [-priority level] [path]
+                  download a file or directory, the policy overrides the
+                  conflict option for just this download
+  priority <level> [path]
+                  change the priority of queued downloads from this peer at or
+                  under the path: paused, lowest, low, normal, high or highest
+
+downloads:
+  queue           show queued, active and failed downloads with their ids
+                  and who else they can be had from
+  transfers [-w]  show what's being transferred, -w keeps showing it every
+                  second until enter is pressed
+  cancel [-delete] <id>
+                  remove a download from the queue, stopping it if it's active,
+                  -delete also removes what has been downloaded so far
+  pause <id>      keep a download from starting, stopping it if it's active
+  resume <id>     let a paused download start again
+  failed          show failed downloads, why they failed and when they'll be
+                  tried again
+  retry <id>|all  try failed downloads again now
 
 sharing:
-  share <name> <directory>
+  share [-<rule> <value>...] <name> <directory>
+                  share a directory, rules apply only to this share:
+      -exclude glob,...   -minsize size   -allowext ext,...   -hidden true
+      -maxsize size       -denyext ext,...    -symlinks policy
   unshare <name>
 
 configuration:
@@ -479,6 +714,34 @@ configuration:
       download string       Path at which to store downloads
       ulslots  integer      Number of upload slots to have
       dlslots  integer      Number of download slots to have
+      hidden   true|false   Don't share files or directories starting with '.'
+      exclude  glob,...     Don't share anything matching these globs
+      minsize  size         Don't share files smaller than this, 0 for none
+      maxsize  size         Don't share files larger than this, 0 for none
+      allowext ext,...      Only share files with these extensions
+      denyext  ext,...      Don't share files with these extensions
+                            (use 'none' to clear any list)
+      symlinks policy       follow, skip, or follow only links which point
+                            within the share (within), default follow
+      hashers  integer      Number of files to hash at once
+      hashrate size         Most to read per second while hashing, 0 for none
+      hashorder order      smallest, largest or fifo, which files to hash
+                            first, default smallest
+      hashleaves integer    Most tree leaves to keep for each file, more
+                            allow finer verification, 0 for the default
+      conflict policy       What to do when a download's destination exists:
+                            rename, overwrite, skip, or resume if it's the
+                            start of the file, default rename
+      skipshared true|false Don't download files which are already shared,
+                            default true
+      template string       Where downloads go underneath their directory,
+                            from {nick}, {path}, {dir}, {remote_dir}, {name}
+                            and {ext}, default {path}
+      route    <name> [-<rule> <value>...]|none
+                            Send matching downloads elsewhere, the first
+                            route to match is used:
+          -nick nick   -ext ext,...   -minsize size   -maxsize size
+          -dir path    -template string
 `)
   }
 }
@@ -523,11 +786,38 @@ func (t *Terminal) Run() {
         looping = false
       }
     }
+
+    if t.watching && time.Since(t.watched) >= time.Second {
+      t.watched = time.Now()
+      C.fargo_clear_rl()
+      t.showTransfers()
+      C.rl_forced_update_display()
+    }
   }
 
   C.rl_callback_handler_remove()
 }
 
+func (t *Terminal) showTransfers() {
+  transfers := t.client.Transfers()
+  if len(transfers) == 0 {
+    println("no transfers")
+  }
+  for _, x := range transfers {
+    pct := 0.0
+    if x.Total > 0 {
+      pct = float64(x.Done) / float64(x.Total) * 100
+    }
+    eta := "?"
+    if x.ETA > 0 {
+      eta = (x.ETA / time.Second * time.Second).String()
+    }
+    fmt.Printf("%-4s %6.2f%% %10s/s (avg %s/s) eta %-8s %s:%s\n",
+               x.Direction.String(), pct, dc.ByteSize(x.Speed).String(),
+               dc.ByteSize(x.Average).String(), eta, x.Nick, x.File)
+  }
+}
+
 func (t *Terminal) quit() {
   t.client.DisconnectHub()
   os.Stdin.Close()
@@ -555,3 +845,163 @@ func (nl *NickList) Swap(i, j int) {
   nl.Nicks[i], nl.Nicks[j] = nl.Nicks[j], nl.Nicks[i]
   nl.Infos[i], nl.Infos[j] = nl.Infos[j], nl.Infos[i]
 }
+
+/* Helpers for managing the rules which exclude files from being shared */
+
+func splitList(value string) []string {
+  if value == "none" || value == "" {
+    return nil
+  }
+  list := make([]string, 0)
+  for _, s := range strings.Split(value, ",") {
+    if s = strings.TrimSpace(s); s != "" {
+      list = append(list, s)
+    }
+  }
+  return list
+}
+
+func setFilter(f *dc.ShareFilter, option string, value string) error {
+  var err error
+  switch option {
+  case "hidden":   f.HideDotfiles, err = strconv.ParseBool(value)
+  case "exclude":  f.Globs = splitList(value)
+  case "minsize":  f.MinSize, err = dc.ParseByteSize(value)
+  case "maxsize":  f.MaxSize, err = dc.ParseByteSize(value)
+  case "allowext": f.AllowExts = splitList(value)
+  case "denyext":  f.DenyExts = splitList(value)
+  case "symlinks": f.Symlinks, err = dc.ParseSymlinkPolicy(value)
+  default:
+    err = errors.New("unknown sharing rule: " + option)
+  }
+  return err
+}
+
+func showFilter(option string, f *dc.ShareFilter) {
+  switch option {
+  case "hidden":   println("hide dotfiles =", f.HideDotfiles)
+  case "exclude":  println("excluded globs =", strings.Join(f.Globs, ","))
+  case "minsize":  println("minimum size =", f.MinSize.String())
+  case "maxsize":  println("maximum size =", f.MaxSize.String())
+  case "allowext": println("allowed extensions =", strings.Join(f.AllowExts, ","))
+  case "denyext":  println("denied extensions =", strings.Join(f.DenyExts, ","))
+  case "symlinks": println("symlinks =", f.Symlinks.String())
+  }
+}
+
+/* Leading flags of get, returning whatever's left */
+func parseDownloadFlags(opts *dc.DownloadOptions, args string) (string, error) {
+  var err error
+  args = strings.TrimSpace(args)
+  for strings.HasPrefix(args, "-") {
+    parts := strings.SplitN(args, " ", 3)
+    if len(parts) < 2 {
+      return "", errors.New("missing value for " + parts[0])
+    }
+    switch parts[0] {
+    case "-conflict": opts.Conflict, err = dc.ParseConflictPolicy(parts[1])
+    case "-priority": opts.Priority, err = dc.ParsePriority(parts[1])
+    default:
+      err = errors.New("unknown download option: " + parts[0])
+    }
+    if err != nil { return "", err }
+    args = ""
+    if len(parts) == 3 {
+      args = strings.TrimSpace(parts[2])
+    }
+  }
+  return args, nil
+}
+
+/* Helpers for routing downloads */
+
+func setRoute(r *dc.Route, option string, value string) error {
+  var err error
+  switch option {
+  case "nick":     r.Nick = value
+  case "ext":      r.Exts = splitList(value)
+  case "minsize":  r.MinSize, err = dc.ParseByteSize(value)
+  case "maxsize":  r.MaxSize, err = dc.ParseByteSize(value)
+  case "dir":      r.Root = value
+  case "template": r.Template = value
+  default:
+    err = errors.New("unknown routing rule: " + option)
+  }
+  return err
+}
+
+func showRoute(r *dc.Route) {
+  fmt.Printf("route %s:", r.Name)
+  if r.Nick != "" { fmt.Printf(" -nick %s", r.Nick) }
+  if len(r.Exts) > 0 { fmt.Printf(" -ext %s", strings.Join(r.Exts, ",")) }
+  if r.MinSize > 0 { fmt.Printf(" -minsize %s", r.MinSize.String()) }
+  if r.MaxSize > 0 { fmt.Printf(" -maxsize %s", r.MaxSize.String()) }
+  if r.Root != "" { fmt.Printf(" -dir %s", r.Root) }
+  if r.Template != "" { fmt.Printf(" -template %s", r.Template) }
+  fmt.Println()
+}
+
+func parseRouteFlags(args string) (dc.Route, error) {
+  var r dc.Route
+  args = strings.TrimSpace(args)
+  for args != "" {
+    parts := strings.SplitN(args, " ", 3)
+    if !strings.HasPrefix(parts[0], "-") {
+      return r, errors.New("expected a rule, got " + parts[0])
+    }
+    if len(parts) < 2 {
+      return r, errors.New("missing value for " + parts[0])
+    }
+    err := setRoute(&r, parts[0][1:], parts[1])
+    if err != nil { return r, err }
+    args = ""
+    if len(parts) == 3 {
+      args = strings.TrimSpace(parts[2])
+    }
+  }
+  return r, nil
+}
+
+/* Helpers for controlling how shared files are hashed */
+
+func setHashSettings(h *dc.HashSettings, option string, value string) error {
+  var err error
+  switch option {
+  case "hashers":
+    var n int64
+    n, err = strconv.ParseInt(value, 10, 32)
+    h.Workers = int(n)
+  case "hashrate":  h.Rate, err = dc.ParseByteSize(value)
+  case "hashorder": h.Order, err = dc.ParseHashOrder(value)
+  case "hashleaves":
+    var n int64
+    n, err = strconv.ParseInt(value, 10, 32)
+    h.Leaves = int(n)
+  }
+  return err
+}
+
+func showHashSettings(option string, h *dc.HashSettings) {
+  switch option {
+  case "hashers":   println("hashers =", h.Workers)
+  case "hashrate":  println("hash rate =", h.Rate.String() + "/s")
+  case "hashorder": println("hash order =", h.Order.String())
+  case "hashleaves": println("hash leaves =", h.Leaves)
+  }
+}
+
+/* Parses leading "-rule value" pairs off of the arguments to "share" */
+func parseFilterFlags(args string) (dc.ShareFilter, string, error) {
+  var f dc.ShareFilter
+  args = strings.TrimSpace(args)
+  for strings.HasPrefix(args, "-") {
+    parts := strings.SplitN(args, " ", 3)
+    if len(parts) < 3 {
+      return f, "", errors.New("missing value for " + parts[0])
+    }
+    err := setFilter(&f, parts[0][1:], parts[1])
+    if err != nil { return f, "", err }
+    args = strings.TrimSpace(parts[2])
+  }
+  return f, args, nil
+}